@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//verdicts recorded in the audit log.
+const (
+	auditVerdictSchedulable string = "schedulable"
+	auditVerdictUnschedulable string = "unschedulable"
+	auditVerdictInvalidRequest string = "invalid_request"
+	auditVerdictNoRdmaNeeded string = "no_rdma_needed"
+	auditVerdictScored string = "scored"
+	auditVerdictBound string = "bound"
+	auditVerdictBindFailed string = "bind_failed"
+	auditVerdictGangWaiting string = "gang_waiting"
+)
+
+//structure describing what was found when a pod's RDMA interfaces were
+//	placed on one node.
+type audit_node_result struct {
+	Node string `json:"node"`
+	//the node's PFs as the DaemonSet reported them
+	ReportedPFs []rdma_placement.ReportedPF `json:"reported_pfs"`
+	Fits bool `json:"fits"`
+	//free bandwidth (by min tx rate) left on the node after placement
+	Capacity int `json:"capacity"`
+	//the index of the PF each interface was placed on, if it fit
+	Placements []int `json:"placements,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Score *int `json:"score,omitempty"`
+}
+
+//structure describing a single scheduling decision, which is written to
+//	the audit log as one line of JSON.
+type audit_record struct {
+	Time time.Time `json:"time"`
+	Verb string `json:"verb"`
+	PodNamespace string `json:"pod_namespace"`
+	PodName string `json:"pod_name"`
+	PodUID types.UID `json:"pod_uid"`
+	Interfaces []knapsack_pod_placement.RdmaInterfaceRequest `json:"interfaces"`
+	Nodes []audit_node_result `json:"nodes,omitempty"`
+	//only set for the bind verb
+	Node string `json:"node,omitempty"`
+	Placement []rdma_interface_placement `json:"placement,omitempty"`
+	Verdict string `json:"verdict"`
+	Error string `json:"error,omitempty"`
+}
+
+//newAuditRecord starts the audit record of a decision about a pod.
+func newAuditRecord(verb string, pod *v1.Pod) audit_record {
+	return audit_record{
+		Time: time.Now(),
+		Verb: verb,
+		PodNamespace: pod.ObjectMeta.Namespace,
+		PodName: pod.ObjectMeta.Name,
+		PodUID: pod.ObjectMeta.UID,
+	}
+}
+
+//auditNodeResult describes the result of placing a pod on a node for the
+//	audit log.
+func auditNodeResult(node_name string, result node_eligibility) audit_node_result {
+	return audit_node_result{
+		Node: node_name,
+		ReportedPFs: result.reported_pfs,
+		Fits: result.enough_resources,
+		Capacity: result.capacity,
+		Placements: result.placements,
+		Reason: result.ineligibility_reason,
+	}
+}
+
+//auditNodeResults describes the results of placing a pod on each of a list
+//	of nodes for the audit log.
+func auditNodeResults(nodes []v1.Node, results []node_eligibility) []audit_node_result {
+	node_results := make([]audit_node_result, len(results))
+	for i, result := range results {
+		node_results[i] = auditNodeResult(nodes[i].Name, result)
+	}
+	return node_results
+}
+
+//rotating_file is a log file that is rotated once it grows past a maximum
+//	size. the current file is renamed to '<path>.1', the previous '.1' to
+//	'.2', and so on, keeping at most 'max_backups' old files. it is not
+//	safe for concurrent use.
+type rotating_file struct {
+	path string
+	max_size int64
+	max_backups int
+	file *os.File
+	size int64
+}
+
+//openRotatingFile opens (or creates) a log file to append to.
+func openRotatingFile(path string, max_size int64, max_backups int) (*rotating_file, error) {
+	rotating := &rotating_file{
+		path: path,
+		max_size: max_size,
+		max_backups: max_backups,
+	}
+	err := rotating.open()
+	if(err != nil) {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+//open opens the current log file, picking up its size.
+func (rotating *rotating_file) open() error {
+	file, err := os.OpenFile(rotating.path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+	if(err != nil) {
+		return err
+	}
+	info, err := file.Stat()
+	if(err != nil) {
+		file.Close()
+		return err
+	}
+	rotating.file = file
+	rotating.size = info.Size()
+	return nil
+}
+
+//rotate moves the current log file out of the way, shifting the old files
+//	along and dropping the oldest, then starts a new file. the current
+//	file is only closed once the new one is open, so if any step fails it
+//	is still there to write to.
+func (rotating *rotating_file) rotate() error {
+	for i := rotating.max_backups; i >= 1; i-- {
+		source := rotating.path
+		if(i > 1) {
+			source = fmt.Sprintf("%s.%d", rotating.path, i - 1)
+		}
+		err := os.Rename(source, fmt.Sprintf("%s.%d", rotating.path, i))
+		if(err != nil && !os.IsNotExist(err)) {
+			return err
+		}
+	}
+	//with no backups kept, the current file is simply discarded
+	if(rotating.max_backups < 1) {
+		err := os.Remove(rotating.path)
+		if(err != nil && !os.IsNotExist(err)) {
+			return err
+		}
+	}
+
+	old_file := rotating.file
+	err := rotating.open()
+	if(err != nil) {
+		return err
+	}
+	old_file.Close()
+	return nil
+}
+
+//Write appends to the log file, rotating it first if the data would take
+//	it past its maximum size. if rotating fails, the data is written to
+//	the current file anyway, and rotating is tried again on the next
+//	write.
+func (rotating *rotating_file) Write(data []byte) (int, error) {
+	if(rotating.size > 0 && rotating.size + int64(len(data)) > rotating.max_size) {
+		err := rotating.rotate()
+		if(err != nil) {
+			log.Println("Unable to rotate log file ", rotating.path, ", still writing to the current one: ", err)
+		}
+	}
+
+	written, err := rotating.file.Write(data)
+	rotating.size += int64(written)
+	return written, err
+}
+
+//audit_logger writes audit records to a rotating file, one JSON object per
+//	line.
+type audit_logger struct {
+	lock sync.Mutex
+	file *rotating_file
+}
+
+//the audit log shared by all scheduler extender verbs. it is nil when no
+//	audit log file is configured, in which case nothing is recorded.
+var audit_log *audit_logger
+
+//record writes an audit record to the audit log, if there is one.
+func (logger *audit_logger) record(record audit_record) {
+	if(logger == nil) {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if(err != nil) {
+		log.Println("Unable to serialize audit record: ", err)
+		return
+	}
+	line = append(line, '\n')
+
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	_, err = logger.file.Write(line)
+	if(err != nil) {
+		log.Println("Unable to write audit record: ", err)
+	}
+}
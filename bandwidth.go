@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+)
+
+//clusterBandwidthPolicy returns the bandwidth policy used on nodes that
+//	don't override it with labels.
+func clusterBandwidthPolicy() rdma_placement.BandwidthPolicy {
+	placement := currentConfig().Placement
+	return rdma_placement.BandwidthPolicy{
+		Mode: placement.BandwidthMode,
+		OversubscriptionRatio: placement.OversubscriptionRatio,
+	}
+}
+
+//parseOversubscriptionRatio checks that an oversubscription ratio is a
+//	number no smaller than 1.
+func parseOversubscriptionRatio(ratio string) (float64, bool) {
+	value, err := strconv.ParseFloat(ratio, 64)
+	if(err != nil || value < 1) {
+		return 0, false
+	}
+	return value, true
+}
+
+//bandwidthPolicyForNode returns the bandwidth policy that applies to a node.
+//	the cluster-wide policy can be overridden for individual nodes using
+//	the 'rdma_bandwidth_mode' and 'rdma_oversubscription_ratio' labels.
+//	invalid labels are ignored.
+func bandwidthPolicyForNode(node *v1.Node) rdma_placement.BandwidthPolicy {
+	policy := clusterBandwidthPolicy()
+
+	if(node == nil) {
+		return policy
+	}
+
+	if mode_label, found := node.ObjectMeta.Labels[RdmaBandwidthModeLabel]; found {
+		mode, err := rdma_placement.ParseBandwidthMode(mode_label)
+		if(err != nil) {
+			log.Println("Ignoring bandwidth mode label on node ", node.Name, ": ", err)
+		} else {
+			policy.Mode = mode
+		}
+	}
+
+	if ratio_label, found := node.ObjectMeta.Labels[RdmaOversubscriptionRatioLabel]; found {
+		ratio, valid := parseOversubscriptionRatio(ratio_label)
+		if(!valid) {
+			log.Println("Ignoring invalid oversubscription ratio label on node ", node.Name, ": ", ratio_label)
+		} else {
+			policy.OversubscriptionRatio = ratio
+		}
+	}
+
+	return policy
+}
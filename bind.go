@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+//structure describing where one of a pod's requested RDMA interfaces was
+//	placed on the node it was bound to. a list of these is written onto
+//	the pod under the 'rdma_interface_placement' annotation, in the same
+//	order as the interfaces in 'rdma_interfaces_required' (with entries
+//	that have a count repeated that many times).
+type rdma_interface_placement struct {
+	PF string `json:"pf"`
+	MinTxRate uint `json:"min_tx_rate"`
+	MaxTxRate uint `json:"max_tx_rate"`
+	//the NUMA node the PF is attached to, if its DaemonSet reports it
+	NumaNode *int `json:"numa_node,omitempty"`
+	//the network the PF is cabled to, if it is known
+	Network string `json:"network,omitempty"`
+}
+
+//placeInterfacesOnNode re-runs placement of a pod's requested RDMA
+//	interfaces against the current state of a node, and returns which PF
+//	each interface should be placed on, along with the node's PFs as
+//	the DaemonSet reported them. what was found is also filled in on the
+//	audit record.
+func placeInterfacesOnNode(ctx context.Context, pod *v1.Pod, node_name string, audit *audit_record) ([]rdma_interface_placement, []rdma_placement.ReportedPF, error) {
+	interfaces_needed, constraints, err := parseRdmaInterfacesRequired(pod)
+	if(err != nil) {
+		return nil, nil, fmt.Errorf("invalid RDMA resources request: %v", err)
+	}
+	audit.Interfaces = interfaces_needed
+	//nothing to place if the pod doesn't need any RDMA interfaces
+	if(len(interfaces_needed) == 0) {
+		return nil, nil, nil
+	}
+
+	node, err := getNode(node_name)
+	if(err != nil) {
+		return nil, nil, err
+	}
+
+	//query the node and place the pod's interfaces on it
+	node_eligibility_channel := make(chan node_eligibility, 1)
+	queryNode(ctx, 0, node, pod.ObjectMeta.UID, interfaces_needed, constraints, node_eligibility_channel)
+	result := <-node_eligibility_channel
+	audit.Nodes = []audit_node_result{auditNodeResult(node_name, result)}
+	if(!result.enough_resources) {
+		return nil, nil, errors.New(result.ineligibility_reason)
+	}
+
+	//translate the indices of the chosen PFs into their names
+	placement := make([]rdma_interface_placement, len(interfaces_needed))
+	for i, pf_index := range result.placements {
+		placement[i].PF = result.pfs[pf_index].Name
+		placement[i].MinTxRate = interfaces_needed[i].MinTxRate
+		placement[i].MaxTxRate = interfaces_needed[i].MaxTxRate
+		placement[i].NumaNode = result.pfs[pf_index].NumaNode
+		placement[i].Network = result.pfs[pf_index].Network
+	}
+
+	return placement, result.reported_pfs, nil
+}
+
+//node_bind_locks hands out one lock per node, held while a pod being bound
+//	to the node has its interfaces placed and reserved.
+type node_bind_locks struct {
+	lock sync.Mutex
+	nodes map[string]*sync.Mutex
+}
+
+//the locks shared by all bind requests.
+var bind_locks = node_bind_locks{nodes: make(map[string]*sync.Mutex)}
+
+//forNode returns the lock for a node, creating it if needed.
+func (locks *node_bind_locks) forNode(node_name string) *sync.Mutex {
+	locks.lock.Lock()
+	defer locks.lock.Unlock()
+
+	node_lock := locks.nodes[node_name]
+	if(node_lock == nil) {
+		node_lock = &sync.Mutex{}
+		locks.nodes[node_name] = node_lock
+	}
+	return node_lock
+}
+
+//bindPod records the RDMA interface placement for a pod on the node the
+//	core scheduler chose, then binds the pod to that node. the placement
+//	is filled in on the audit record.
+//
+//	resources are only reserved here, not when the pod is filtered: the
+//	filter verb doesn't know which of the nodes it passes the scheduler
+//	will pick. a pod can therefore pass the filter on a node whose
+//	resources are being taken by another pod that is still being bound.
+//	placement is re-run here against the reservations, so such a pod
+//	fails to bind rather than overcommitting the node, and the scheduler
+//	tries it again.
+func bindPod(ctx context.Context, binding_args *schedulerapi.ExtenderBindingArgs, audit *audit_record) error {
+	if(kube_client == nil) {
+		return errors.New("RDMA Scheduler Extension: no connection to the k8s API server is configured.")
+	}
+
+	pod, err := kube_client.CoreV1().Pods(binding_args.PodNamespace).Get(binding_args.PodName, metav1.GetOptions{})
+	if(err != nil) {
+		return err
+	}
+	//make sure we're not looking at a different pod with the same name
+	if(pod.ObjectMeta.UID != binding_args.PodUID) {
+		return fmt.Errorf("pod %s/%s has UID %s, expected %s", binding_args.PodNamespace, binding_args.PodName, pod.ObjectMeta.UID, binding_args.PodUID)
+	}
+
+	//place the pod's interfaces and reserve them while holding the
+	//	node's lock, so that another pod being bound to the same node
+	//	at the same time can't be given the same resources.
+	node_lock := bind_locks.forNode(binding_args.Node)
+	node_lock.Lock()
+	placement, reported_pfs, err := placeInterfacesOnNode(ctx, pod, binding_args.Node, audit)
+	if(err != nil) {
+		node_lock.Unlock()
+		return err
+	}
+	audit.Placement = placement
+	//hold the chosen resources for the pod, so that pods scheduled
+	//	before the DaemonSet reports them as used can't be placed on
+	//	them too.
+	if(placement != nil) {
+		reservations.reserve(pod.ObjectMeta.UID, binding_args.Node, reported_pfs, placement)
+	}
+	node_lock.Unlock()
+
+	//if the pod doesn't end up bound, it won't be using the resources
+	//	reserved for it.
+	var bound bool = false
+	defer func() {
+		if(!bound) {
+			reservations.release(pod.ObjectMeta.UID)
+		}
+	}()
+
+	//write the placement onto the pod so that the CNI and device setup
+	//	on the node can use the same PFs that were chosen here.
+	if(placement != nil) {
+		log.Printf("Placement of pod's RDMA interfaces on node %s: %+v", binding_args.Node, placement)
+
+		placement_json, err := json.Marshal(placement)
+		if(err != nil) {
+			return err
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{
+					currentConfig().Annotations.InterfacePlacement: string(placement_json),
+				},
+			},
+		})
+		if(err != nil) {
+			return err
+		}
+		_, err = kube_client.CoreV1().Pods(binding_args.PodNamespace).Patch(binding_args.PodName, types.MergePatchType, patch)
+		if(err != nil) {
+			return err
+		}
+	}
+
+	//bind the pod to the chosen node
+	err = kube_client.CoreV1().Pods(binding_args.PodNamespace).Bind(&v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: binding_args.PodNamespace,
+			Name: binding_args.PodName,
+			UID: binding_args.PodUID,
+		},
+		Target: v1.ObjectReference{
+			Kind: "Node",
+			Name: binding_args.Node,
+		},
+	})
+	if(err != nil) {
+		return err
+	}
+	bound = true
+	if(placement != nil) {
+		recordPlacement(pod, binding_args.Node, placement)
+	}
+
+	return nil
+}
+
+// HandleSchedulerBindRequest is a callback function that processes incoming
+//	bind requests to the RDMA scheduler extender.
+func HandleSchedulerBindRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	//reject empty requests
+	if(request.Body == nil) {
+		log.Println("Got empty http bind request.")
+		http.Error(response, "Request body was empty.", 400)
+		return
+	}
+
+	var binding_args schedulerapi.ExtenderBindingArgs
+	var binding_result schedulerapi.ExtenderBindingResult
+
+	err := json.NewDecoder(request.Body).Decode(&binding_args)
+	if(err != nil) {
+		log.Println("Got http request with malformatted scheduler extender binding arguments.")
+		binding_result.Error = err.Error()
+	} else {
+		log.Println("Got request to bind pod: ", binding_args.PodNamespace, "/", binding_args.PodName, " to node: ", binding_args.Node)
+		audit := audit_record{
+			Time: time.Now(),
+			Verb: "bind",
+			PodNamespace: binding_args.PodNamespace,
+			PodName: binding_args.PodName,
+			PodUID: binding_args.PodUID,
+			Node: binding_args.Node,
+			Verdict: auditVerdictBound,
+		}
+		err = bindPod(request.Context(), &binding_args, &audit)
+		if(err != nil) {
+			log.Println("Failed to bind pod: ", err)
+			binding_result.Error = err.Error()
+			audit.Verdict = auditVerdictBindFailed
+			audit.Error = err.Error()
+		}
+		audit_log.record(audit)
+	}
+
+	//serialize the results structure into a response
+	response_body, err := json.Marshal(binding_result)
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
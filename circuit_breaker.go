@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+//structure describing the recent health of the DaemonSet on one node.
+type node_circuit struct {
+	consecutive_failures int
+	//while the circuit is open, the node isn't queried until this time
+	open_until time.Time
+	//once the circuit has been open for long enough, a single trial
+	//	query is let through to see whether the DaemonSet is back
+	trial_in_flight bool
+}
+
+//circuit_breaker keeps track of which nodes' DaemonSets keep failing, so
+//	that they can be skipped quickly instead of costing a full query
+//	timeout for every pod. after 'failure_threshold' failed queries in a
+//	row a node's circuit opens, and the node is skipped until
+//	'cooldown' has passed. one trial query is then let through, which
+//	closes the circuit if it succeeds or opens it again if it fails.
+type circuit_breaker struct {
+	lock sync.Mutex
+	failure_threshold int
+	cooldown time.Duration
+	nodes map[string]*node_circuit
+}
+
+//the circuit breaker shared by all queries to DaemonSets.
+var node_breaker = newCircuitBreaker(RdmaSchedulerDefaultCircuitBreakerFailures, RdmaSchedulerDefaultCircuitBreakerCooldown)
+
+//newCircuitBreaker creates a circuit breaker with every node's circuit
+//	closed.
+func newCircuitBreaker(failure_threshold int, cooldown time.Duration) *circuit_breaker {
+	return &circuit_breaker{
+		failure_threshold: failure_threshold,
+		cooldown: cooldown,
+		nodes: make(map[string]*node_circuit),
+	}
+}
+
+//setLimits changes how many failures in a row open a circuit, and how long
+//	it stays open. circuits that are already open keep their current
+//	cooldown.
+func (breaker *circuit_breaker) setLimits(failure_threshold int, cooldown time.Duration) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.failure_threshold = failure_threshold
+	breaker.cooldown = cooldown
+}
+
+//allow determines whether a node's DaemonSet may be queried now.
+func (breaker *circuit_breaker) allow(node_name string) bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	circuit := breaker.nodes[node_name]
+	if(circuit == nil || circuit.consecutive_failures < breaker.failure_threshold) {
+		return true
+	}
+	if(time.Now().Before(circuit.open_until) || circuit.trial_in_flight) {
+		return false
+	}
+	circuit.trial_in_flight = true
+	return true
+}
+
+//succeeded records that a node's DaemonSet answered, closing its circuit.
+func (breaker *circuit_breaker) succeeded(node_name string) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	if(breaker.nodes[node_name] != nil && breaker.nodes[node_name].consecutive_failures >= breaker.failure_threshold) {
+		log.Println("RDMA hardware DaemonSet on node ", node_name, " is answering again, closing its circuit.")
+	}
+	delete(breaker.nodes, node_name)
+}
+
+//failed records that a node's DaemonSet couldn't be queried, opening its
+//	circuit once it has failed too many times in a row.
+func (breaker *circuit_breaker) failed(node_name string) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	circuit := breaker.nodes[node_name]
+	if(circuit == nil) {
+		circuit = &node_circuit{}
+		breaker.nodes[node_name] = circuit
+	}
+	circuit.consecutive_failures += 1
+	circuit.trial_in_flight = false
+	if(circuit.consecutive_failures >= breaker.failure_threshold) {
+		circuit.open_until = time.Now().Add(breaker.cooldown)
+		log.Println("RDMA hardware DaemonSet on node ", node_name, " failed ", circuit.consecutive_failures, " times in a row, skipping it for ", breaker.cooldown)
+	}
+}
+
+//abandoned records that a query to a node's DaemonSet was cancelled before
+//	it could tell whether the DaemonSet is healthy.
+func (breaker *circuit_breaker) abandoned(node_name string) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	circuit := breaker.nodes[node_name]
+	if(circuit != nil) {
+		circuit.trial_in_flight = false
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+//settings of the extender's servers. these only take effect when the
+//	extender starts, changes to them in the config file are ignored until
+//	it is restarted.
+type server_config struct {
+	Port string `json:"port"`
+	FilterPath string `json:"filterPath"`
+	PrioritizePath string `json:"prioritizePath"`
+	BindPath string `json:"bindPath"`
+	PreemptPath string `json:"preemptPath"`
+	TLSCertFile string `json:"tlsCertFile"`
+	TLSKeyFile string `json:"tlsKeyFile"`
+	TLSClientCAFile string `json:"tlsClientCAFile"`
+	WebhookPort string `json:"webhookPort"`
+	WebhookTLSCertFile string `json:"webhookTLSCertFile"`
+	WebhookTLSKeyFile string `json:"webhookTLSKeyFile"`
+	AuditLogFile string `json:"auditLogFile"`
+	AuditLogMaxSizeMB int `json:"auditLogMaxSizeMB"`
+	AuditLogMaxBackups int `json:"auditLogMaxBackups"`
+	DrainTimeoutSeconds int `json:"drainTimeoutSeconds"`
+	ShutdownGraceSeconds int `json:"shutdownGraceSeconds"`
+}
+
+//settings for querying the RDMA hardware DaemonSets.
+type node_query_config struct {
+	Scheme string `json:"scheme"`
+	Port string `json:"port"`
+	TimeoutMs int `json:"timeoutMs"`
+	CAFile string `json:"caFile"`
+	ServerName string `json:"serverName"`
+	ClientCertFile string `json:"clientCertFile"`
+	ClientKeyFile string `json:"clientKeyFile"`
+	TokenFile string `json:"tokenFile"`
+	Retries int `json:"retries"`
+	RetryBackoffMs int `json:"retryBackoffMs"`
+	CircuitBreakerFailures int `json:"circuitBreakerFailures"`
+	CircuitBreakerCooldownMs int `json:"circuitBreakerCooldownMs"`
+}
+
+//the keys of the pod annotations the extender reads and writes.
+type annotations_config struct {
+	InterfacesRequired string `json:"interfacesRequired"`
+	InterfacePlacement string `json:"interfacePlacement"`
+}
+
+//settings for how pods are placed on nodes.
+type placement_config struct {
+	ScoringPolicy string `json:"scoringPolicy"`
+	BandwidthMode rdma_placement.BandwidthMode `json:"bandwidthMode"`
+	OversubscriptionRatio float64 `json:"oversubscriptionRatio"`
+	SolverGoal rdma_placement.PlacementGoal `json:"solverGoal"`
+	SolverBudgetMs int `json:"solverBudgetMs"`
+}
+
+//settings for how long cached RDMA resource information is used.
+type cache_config struct {
+	ReservationTTLSeconds int `json:"reservationTTLSeconds"`
+	InventoryPollIntervalMs int `json:"inventoryPollIntervalMs"`
+	InventoryMaxAgeMs int `json:"inventoryMaxAgeMs"`
+}
+
+//settings that limit how much work is done for each request.
+type concurrency_config struct {
+	NodeQueryWorkers int `json:"nodeQueryWorkers"`
+	RequestDeadlineMs int `json:"requestDeadlineMs"`
+}
+
+//settings for favouring nodes close to the other pods of the same job on
+//	the fabric. the topology is read from either a file or a key of a
+//	ConfigMap ('namespace/name').
+type topology_config struct {
+	File string `json:"file"`
+	ConfigMap string `json:"configMap"`
+	ConfigMapKey string `json:"configMapKey"`
+	JobLabel string `json:"jobLabel"`
+	Weight float64 `json:"weight"`
+	LeafWeight int `json:"leafWeight"`
+	RailWeight int `json:"railWeight"`
+}
+
+//extender_config holds all of the extender's settings. they come from
+//	environment variables, which may be overridden by a config file.
+type extender_config struct {
+	Server server_config `json:"server"`
+	NodeQuery node_query_config `json:"nodeQuery"`
+	Annotations annotations_config `json:"annotations"`
+	Placement placement_config `json:"placement"`
+	Cache cache_config `json:"cache"`
+	Concurrency concurrency_config `json:"concurrency"`
+	Topology topology_config `json:"topology"`
+}
+
+//the settings in use. always holds an *extender_config, which is replaced
+//	as a whole (never modified) when the config file is reloaded.
+var active_config atomic.Value
+
+func init() {
+	active_config.Store(defaultConfig())
+}
+
+//currentConfig returns the settings in use.
+func currentConfig() *extender_config {
+	return active_config.Load().(*extender_config)
+}
+
+//placementSolver returns how the placement of a pod's interfaces on a node
+//	is searched for.
+func placementSolver() rdma_placement.Solver {
+	placement := currentConfig().Placement
+	return rdma_placement.Solver{
+		Goal: placement.SolverGoal,
+		Budget: time.Duration(placement.SolverBudgetMs) * time.Millisecond,
+	}
+}
+
+//defaultConfig returns the settings used when nothing else is configured.
+func defaultConfig() *extender_config {
+	return &extender_config{
+		Server: server_config{
+			Port: RdmaSchedulerExtenderDefaultPort,
+			FilterPath: RdmaSchedulerExtenderHttpListenPath,
+			PrioritizePath: RdmaSchedulerExtenderPrioritizePath,
+			BindPath: RdmaSchedulerExtenderBindPath,
+			PreemptPath: RdmaSchedulerExtenderPreemptPath,
+			WebhookPort: RdmaWebhookDefaultPort,
+			AuditLogMaxSizeMB: RdmaSchedulerDefaultAuditLogMaxSizeMB,
+			AuditLogMaxBackups: RdmaSchedulerDefaultAuditLogMaxBackups,
+			DrainTimeoutSeconds: int(RdmaSchedulerDefaultDrainTimeout / time.Second),
+			ShutdownGraceSeconds: int(RdmaSchedulerDefaultShutdownGrace / time.Second),
+		},
+		NodeQuery: node_query_config{
+			Scheme: "http",
+			Port: rdma_hardware_info.DefaultPort,
+			TimeoutMs: RdmaSchedulerDefaultNodeQueryTimeout,
+			Retries: RdmaSchedulerDefaultNodeQueryRetries,
+			RetryBackoffMs: int(RdmaSchedulerDefaultNodeQueryRetryBackoff / time.Millisecond),
+			CircuitBreakerFailures: RdmaSchedulerDefaultCircuitBreakerFailures,
+			CircuitBreakerCooldownMs: int(RdmaSchedulerDefaultCircuitBreakerCooldown / time.Millisecond),
+		},
+		Annotations: annotations_config{
+			InterfacesRequired: RdmaInterfacesRequiredAnnotation,
+			InterfacePlacement: RdmaInterfacePlacementAnnotation,
+		},
+		Placement: placement_config{
+			ScoringPolicy: RdmaSchedulerDefaultScoringPolicy,
+			BandwidthMode: RdmaSchedulerDefaultBandwidthMode,
+			OversubscriptionRatio: RdmaSchedulerDefaultOversubscriptionRatio,
+			SolverGoal: RdmaSchedulerDefaultSolverGoal,
+			SolverBudgetMs: int(RdmaSchedulerDefaultSolverBudget / time.Millisecond),
+		},
+		Cache: cache_config{
+			ReservationTTLSeconds: int(RdmaSchedulerDefaultReservationTTL / time.Second),
+			InventoryPollIntervalMs: int(RdmaSchedulerDefaultInventoryPollInterval / time.Millisecond),
+			InventoryMaxAgeMs: int(RdmaSchedulerDefaultInventoryMaxAge / time.Millisecond),
+		},
+		Concurrency: concurrency_config{
+			NodeQueryWorkers: RdmaSchedulerDefaultNodeQueryWorkers,
+			RequestDeadlineMs: int(RdmaSchedulerDefaultRequestDeadline / time.Millisecond),
+		},
+		Topology: topology_config{
+			ConfigMapKey: RdmaSchedulerDefaultTopologyConfigMapKey,
+			JobLabel: RdmaJobLabel,
+			Weight: RdmaSchedulerDefaultTopologyWeight,
+			LeafWeight: RdmaSchedulerDefaultTopologyLeafWeight,
+			RailWeight: RdmaSchedulerDefaultTopologyRailWeight,
+		},
+	}
+}
+
+//configFromEnv returns the default settings, overridden by any environment
+//	variables that are set.
+func configFromEnv() *extender_config {
+	config := defaultConfig()
+
+	config.Server.Port = getEnvVar("PORT", config.Server.Port)
+	config.Server.TLSCertFile = getEnvVar("TLS_CERT_FILE", config.Server.TLSCertFile)
+	config.Server.TLSKeyFile = getEnvVar("TLS_KEY_FILE", config.Server.TLSKeyFile)
+	config.Server.TLSClientCAFile = getEnvVar("TLS_CLIENT_CA_FILE", config.Server.TLSClientCAFile)
+	config.Server.WebhookPort = getEnvVar("WEBHOOK_PORT", config.Server.WebhookPort)
+	config.Server.WebhookTLSCertFile = getEnvVar("WEBHOOK_TLS_CERT_FILE", config.Server.WebhookTLSCertFile)
+	config.Server.WebhookTLSKeyFile = getEnvVar("WEBHOOK_TLS_KEY_FILE", config.Server.WebhookTLSKeyFile)
+	config.Server.AuditLogFile = getEnvVar("AUDIT_LOG_FILE", config.Server.AuditLogFile)
+	config.Server.AuditLogMaxSizeMB = getEnvVarInt("AUDIT_LOG_MAX_SIZE_MB", config.Server.AuditLogMaxSizeMB)
+	config.Server.AuditLogMaxBackups = getEnvVarInt("AUDIT_LOG_MAX_BACKUPS", config.Server.AuditLogMaxBackups)
+	config.Server.DrainTimeoutSeconds = getEnvVarInt("DRAIN_TIMEOUT_SECONDS", config.Server.DrainTimeoutSeconds)
+	config.Server.ShutdownGraceSeconds = getEnvVarInt("SHUTDOWN_GRACE_SECONDS", config.Server.ShutdownGraceSeconds)
+
+	config.NodeQuery.Scheme = getEnvVar("NODE_QUERY_SCHEME", config.NodeQuery.Scheme)
+	config.NodeQuery.Port = getEnvVar("NODE_QUERY_PORT", config.NodeQuery.Port)
+	config.NodeQuery.TimeoutMs = getEnvVarInt("NODE_QUERY_TIMEOUT_MS", config.NodeQuery.TimeoutMs)
+	config.NodeQuery.CAFile = getEnvVar("NODE_QUERY_CA_FILE", config.NodeQuery.CAFile)
+	config.NodeQuery.ServerName = getEnvVar("NODE_QUERY_SERVER_NAME", config.NodeQuery.ServerName)
+	config.NodeQuery.ClientCertFile = getEnvVar("NODE_QUERY_CLIENT_CERT_FILE", config.NodeQuery.ClientCertFile)
+	config.NodeQuery.ClientKeyFile = getEnvVar("NODE_QUERY_CLIENT_KEY_FILE", config.NodeQuery.ClientKeyFile)
+	config.NodeQuery.TokenFile = getEnvVar("NODE_QUERY_TOKEN_FILE", config.NodeQuery.TokenFile)
+	config.NodeQuery.Retries = getEnvVarInt("NODE_QUERY_RETRIES", config.NodeQuery.Retries)
+	config.NodeQuery.RetryBackoffMs = getEnvVarInt("NODE_QUERY_RETRY_BACKOFF_MS", config.NodeQuery.RetryBackoffMs)
+	config.NodeQuery.CircuitBreakerFailures = getEnvVarInt("CIRCUIT_BREAKER_FAILURES", config.NodeQuery.CircuitBreakerFailures)
+	config.NodeQuery.CircuitBreakerCooldownMs = getEnvVarInt("CIRCUIT_BREAKER_COOLDOWN_MS", config.NodeQuery.CircuitBreakerCooldownMs)
+
+	config.Placement.ScoringPolicy = getEnvVar("SCORING_POLICY", config.Placement.ScoringPolicy)
+	config.Placement.BandwidthMode = rdma_placement.BandwidthMode(getEnvVar("BANDWIDTH_MODE", string(config.Placement.BandwidthMode)))
+	ratio := getEnvVar("BANDWIDTH_OVERSUBSCRIPTION_RATIO", strconv.FormatFloat(config.Placement.OversubscriptionRatio, 'g', -1, 64))
+	var err error
+	config.Placement.OversubscriptionRatio, err = strconv.ParseFloat(ratio, 64)
+	if(err != nil) {
+		log.Fatal("Invalid BANDWIDTH_OVERSUBSCRIPTION_RATIO, it must be a number: ", ratio)
+	}
+	config.Placement.SolverGoal = rdma_placement.PlacementGoal(getEnvVar("SOLVER_GOAL", string(config.Placement.SolverGoal)))
+	config.Placement.SolverBudgetMs = getEnvVarInt("SOLVER_BUDGET_MS", config.Placement.SolverBudgetMs)
+
+	config.Cache.ReservationTTLSeconds = getEnvVarInt("RESERVATION_TTL_SECONDS", config.Cache.ReservationTTLSeconds)
+	config.Cache.InventoryPollIntervalMs = getEnvVarInt("INVENTORY_POLL_INTERVAL_MS", config.Cache.InventoryPollIntervalMs)
+	config.Cache.InventoryMaxAgeMs = getEnvVarInt("INVENTORY_MAX_AGE_MS", config.Cache.InventoryMaxAgeMs)
+
+	config.Concurrency.NodeQueryWorkers = getEnvVarInt("NODE_QUERY_WORKERS", config.Concurrency.NodeQueryWorkers)
+	config.Concurrency.RequestDeadlineMs = getEnvVarInt("REQUEST_DEADLINE_MS", config.Concurrency.RequestDeadlineMs)
+
+	config.Topology.File = getEnvVar("TOPOLOGY_FILE", config.Topology.File)
+	config.Topology.ConfigMap = getEnvVar("TOPOLOGY_CONFIGMAP", config.Topology.ConfigMap)
+	config.Topology.JobLabel = getEnvVar("TOPOLOGY_JOB_LABEL", config.Topology.JobLabel)
+
+	return config
+}
+
+//loadConfigFile reads a YAML or JSON config file. settings left out of the
+//	file keep their values from 'base'. unknown settings are rejected, so
+//	that typos don't go unnoticed.
+func loadConfigFile(path string, base *extender_config) (*extender_config, error) {
+	data, err := ioutil.ReadFile(path)
+	if(err != nil) {
+		return nil, err
+	}
+
+	config := *base
+	err = yaml.UnmarshalStrict(data, &config)
+	if(err != nil) {
+		return nil, err
+	}
+	return &config, nil
+}
+
+//checkPositive adds an error to a list if a setting is less than 1.
+func checkPositive(all_errs field.ErrorList, path *field.Path, value int) field.ErrorList {
+	if(value < 1) {
+		return append(all_errs, field.Invalid(path, value, "must be at least 1"))
+	}
+	return all_errs
+}
+
+//checkNotNegative adds an error to a list if a setting is less than 0.
+func checkNotNegative(all_errs field.ErrorList, path *field.Path, value int) field.ErrorList {
+	if(value < 0) {
+		return append(all_errs, field.Invalid(path, value, "must not be negative"))
+	}
+	return all_errs
+}
+
+//validate checks the settings, and returns the errors found with each of
+//	them.
+func (config *extender_config) validate() field.ErrorList {
+	var all_errs field.ErrorList
+
+	server_path := field.NewPath("server")
+	if(config.Server.Port == "") {
+		all_errs = append(all_errs, field.Required(server_path.Child("port"), ""))
+	}
+	verb_paths := map[string]string{
+		"filterPath": config.Server.FilterPath,
+		"prioritizePath": config.Server.PrioritizePath,
+		"bindPath": config.Server.BindPath,
+		"preemptPath": config.Server.PreemptPath,
+	}
+	for name, verb_path := range verb_paths {
+		if(!strings.HasPrefix(verb_path, "/")) {
+			all_errs = append(all_errs, field.Invalid(server_path.Child(name), verb_path, "must start with '/'"))
+		}
+	}
+	all_errs = checkPositive(all_errs, server_path.Child("auditLogMaxSizeMB"), config.Server.AuditLogMaxSizeMB)
+	all_errs = checkNotNegative(all_errs, server_path.Child("auditLogMaxBackups"), config.Server.AuditLogMaxBackups)
+	all_errs = checkNotNegative(all_errs, server_path.Child("drainTimeoutSeconds"), config.Server.DrainTimeoutSeconds)
+	all_errs = checkNotNegative(all_errs, server_path.Child("shutdownGraceSeconds"), config.Server.ShutdownGraceSeconds)
+
+	node_query_path := field.NewPath("nodeQuery")
+	if(config.NodeQuery.Scheme != "http" && config.NodeQuery.Scheme != "https") {
+		all_errs = append(all_errs, field.NotSupported(node_query_path.Child("scheme"), config.NodeQuery.Scheme, []string{"http", "https"}))
+	}
+	if(config.NodeQuery.Port == "") {
+		all_errs = append(all_errs, field.Required(node_query_path.Child("port"), ""))
+	}
+	//with plain http a token would be sent in cleartext, and a client
+	//	certificate would never be presented
+	if(config.NodeQuery.Scheme == "http") {
+		if(config.NodeQuery.TokenFile != "") {
+			all_errs = append(all_errs, field.Forbidden(node_query_path.Child("tokenFile"), "may only be given with the 'https' scheme"))
+		}
+		if(config.NodeQuery.ClientCertFile != "") {
+			all_errs = append(all_errs, field.Forbidden(node_query_path.Child("clientCertFile"), "may only be given with the 'https' scheme"))
+		}
+		if(config.NodeQuery.ClientKeyFile != "") {
+			all_errs = append(all_errs, field.Forbidden(node_query_path.Child("clientKeyFile"), "may only be given with the 'https' scheme"))
+		}
+	}
+	all_errs = checkPositive(all_errs, node_query_path.Child("timeoutMs"), config.NodeQuery.TimeoutMs)
+	all_errs = checkNotNegative(all_errs, node_query_path.Child("retries"), config.NodeQuery.Retries)
+	all_errs = checkPositive(all_errs, node_query_path.Child("retryBackoffMs"), config.NodeQuery.RetryBackoffMs)
+	all_errs = checkPositive(all_errs, node_query_path.Child("circuitBreakerFailures"), config.NodeQuery.CircuitBreakerFailures)
+	all_errs = checkNotNegative(all_errs, node_query_path.Child("circuitBreakerCooldownMs"), config.NodeQuery.CircuitBreakerCooldownMs)
+
+	annotations_path := field.NewPath("annotations")
+	if(config.Annotations.InterfacesRequired == "") {
+		all_errs = append(all_errs, field.Required(annotations_path.Child("interfacesRequired"), ""))
+	}
+	if(config.Annotations.InterfacePlacement == "") {
+		all_errs = append(all_errs, field.Required(annotations_path.Child("interfacePlacement"), ""))
+	}
+
+	placement_path := field.NewPath("placement")
+	_, policy_found := scoring_policies[config.Placement.ScoringPolicy]
+	if(!policy_found) {
+		policy_names := make([]string, 0, len(scoring_policies))
+		for name := range scoring_policies {
+			policy_names = append(policy_names, name)
+		}
+		sort.Strings(policy_names)
+		all_errs = append(all_errs, field.NotSupported(placement_path.Child("scoringPolicy"), config.Placement.ScoringPolicy, policy_names))
+	}
+	_, err := rdma_placement.ParseBandwidthMode(string(config.Placement.BandwidthMode))
+	if(err != nil) {
+		all_errs = append(all_errs, field.Invalid(placement_path.Child("bandwidthMode"), config.Placement.BandwidthMode, err.Error()))
+	}
+	if(config.Placement.OversubscriptionRatio < 1) {
+		all_errs = append(all_errs, field.Invalid(placement_path.Child("oversubscriptionRatio"), config.Placement.OversubscriptionRatio, "must be no smaller than 1"))
+	}
+	_, err = rdma_placement.ParsePlacementGoal(string(config.Placement.SolverGoal))
+	if(err != nil) {
+		all_errs = append(all_errs, field.Invalid(placement_path.Child("solverGoal"), config.Placement.SolverGoal, err.Error()))
+	}
+	all_errs = checkNotNegative(all_errs, placement_path.Child("solverBudgetMs"), config.Placement.SolverBudgetMs)
+
+	cache_path := field.NewPath("cache")
+	all_errs = checkPositive(all_errs, cache_path.Child("reservationTTLSeconds"), config.Cache.ReservationTTLSeconds)
+	all_errs = checkPositive(all_errs, cache_path.Child("inventoryPollIntervalMs"), config.Cache.InventoryPollIntervalMs)
+	all_errs = checkPositive(all_errs, cache_path.Child("inventoryMaxAgeMs"), config.Cache.InventoryMaxAgeMs)
+
+	concurrency_path := field.NewPath("concurrency")
+	all_errs = checkPositive(all_errs, concurrency_path.Child("nodeQueryWorkers"), config.Concurrency.NodeQueryWorkers)
+	all_errs = checkPositive(all_errs, concurrency_path.Child("requestDeadlineMs"), config.Concurrency.RequestDeadlineMs)
+
+	topology_path := field.NewPath("topology")
+	if(config.Topology.File != "" && config.Topology.ConfigMap != "") {
+		all_errs = append(all_errs, field.Forbidden(topology_path.Child("configMap"), "may not be given along with 'file'"))
+	}
+	if(config.Topology.ConfigMap != "") {
+		parts := strings.Split(config.Topology.ConfigMap, "/")
+		if(len(parts) != 2 || parts[0] == "" || parts[1] == "") {
+			all_errs = append(all_errs, field.Invalid(topology_path.Child("configMap"), config.Topology.ConfigMap, "must be of the form 'namespace/name'"))
+		}
+		if(config.Topology.ConfigMapKey == "") {
+			all_errs = append(all_errs, field.Required(topology_path.Child("configMapKey"), ""))
+		}
+	}
+	for _, msg := range validation.IsQualifiedName(config.Topology.JobLabel) {
+		all_errs = append(all_errs, field.Invalid(topology_path.Child("jobLabel"), config.Topology.JobLabel, msg))
+	}
+	if(config.Topology.Weight < 0 || config.Topology.Weight > 1) {
+		all_errs = append(all_errs, field.Invalid(topology_path.Child("weight"), config.Topology.Weight, "must be between 0 and 1"))
+	}
+	all_errs = checkNotNegative(all_errs, topology_path.Child("leafWeight"), config.Topology.LeafWeight)
+	all_errs = checkNotNegative(all_errs, topology_path.Child("railWeight"), config.Topology.RailWeight)
+
+	return all_errs
+}
+
+//activateConfig puts a validated set of settings into use, updating the
+//	parts of the extender that keep their own copy of a setting.
+func activateConfig(config *extender_config) error {
+	query, err := newNodeQueryClient(config.NodeQuery)
+	if(err != nil) {
+		return err
+	}
+
+	//the replaced client's idle connections would otherwise be kept
+	//	open for good
+	previous_query := currentNodeQuery()
+	active_node_query.Store(query)
+	previous_query.client.CloseIdleConnections()
+	node_breaker.setLimits(config.NodeQuery.CircuitBreakerFailures, time.Duration(config.NodeQuery.CircuitBreakerCooldownMs) * time.Millisecond)
+	reservations.setTTL(time.Duration(config.Cache.ReservationTTLSeconds) * time.Second)
+	inventory.setMaxAge(time.Duration(config.Cache.InventoryMaxAgeMs) * time.Millisecond)
+	active_config.Store(config)
+	return nil
+}
+
+//reloadConfigFile loads the config file over the settings from environment
+//	variables and puts it into use. changes to settings that only take
+//	effect at startup are ignored, with a warning. if the file is invalid
+//	the current settings are kept.
+func reloadConfigFile(path string, env_config *extender_config) {
+	config, err := loadConfigFile(path, env_config)
+	if(err != nil) {
+		log.Println("Unable to reload config file, keeping the current settings: ", err)
+		return
+	}
+	errs := config.validate()
+	if(len(errs) > 0) {
+		log.Println("Invalid config file, keeping the current settings: ", errs.ToAggregate())
+		return
+	}
+
+	current := currentConfig()
+	if(!reflect.DeepEqual(config.Server, current.Server)) {
+		log.Println("Changes to the 'server' settings in the config file only take effect after a restart.")
+		config.Server = current.Server
+	}
+
+	err = activateConfig(config)
+	if(err != nil) {
+		log.Println("Unable to apply config file, keeping the current settings: ", err)
+		return
+	}
+	log.Println("Reloaded config file: ", path)
+}
+
+//watchConfigFile reloads the config file whenever it changes, until the
+//	context is cancelled.
+func watchConfigFile(ctx context.Context, path string, env_config *extender_config, interval time.Duration) {
+	var last_modified time.Time
+	info, err := os.Stat(path)
+	if(err == nil) {
+		last_modified = info.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		info, err := os.Stat(path)
+		if(err != nil) {
+			log.Println("Unable to check config file for changes: ", err)
+			continue
+		}
+		if(info.ModTime().Equal(last_modified)) {
+			continue
+		}
+		last_modified = info.ModTime()
+		reloadConfigFile(path, env_config)
+	}
+}
+
+// HandleConfigRequest is a callback function that shows the settings in use.
+func HandleConfigRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	response_body, err := json.MarshalIndent(currentConfig(), "", "  ")
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
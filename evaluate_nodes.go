@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//runWorkerPool calls 'work' with each index from 0 up to 'count', from a
+//	pool of at most 'workers' goroutines. indices stop being handed out
+//	once the context is done. the returned channel is closed once every
+//	worker has finished.
+func runWorkerPool(ctx context.Context, count int, workers int, work func(int)) <-chan struct{} {
+	finished := make(chan struct{})
+
+	indices := make(chan int, count)
+	for i := 0; i < count; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	if(workers > count) {
+		workers = count
+	}
+	var wait_group sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			for i := range indices {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				work(i)
+			}
+		}()
+	}
+
+	go func() {
+		wait_group.Wait()
+		close(finished)
+	}()
+	return finished
+}
+
+//evaluateNodes determines whether each of a list of potential nodes can
+//	satisfy a pod's RDMA interface requests. the nodes are checked by a
+//	pool of no more than 'nodeQueryWorkers' workers, and checking stops
+//	once the request deadline passes or the context is cancelled,
+//	abandoning any outstanding queries to DaemonSets. the results are
+//	returned in the same order as the nodes.
+func evaluateNodes(ctx context.Context,
+	nodes []v1.Node,
+	pod_uid types.UID,
+	interfaces_needed []knapsack_pod_placement.RdmaInterfaceRequest,
+	constraints rdma_placement.Constraints) []node_eligibility {
+
+	//nodes we don't hear back about in time are reported as such
+	results := make([]node_eligibility, len(nodes))
+	for i := range results {
+		results[i].index = i
+		results[i].enough_resources = false
+		results[i].ineligibility_reason = "RDMA Scheduler Extension: Timed out while checking node's RDMA resources."
+		results[i].ineligibility_cause = ineligibleTimeout
+	}
+	if(len(nodes) == 0) {
+		return results
+	}
+
+	//the channel that results come back on is large enough that workers
+	//	never block on it, even if we've stopped reading because the
+	//	deadline passed.
+	node_eligibility_channel := make(chan node_eligibility, len(nodes))
+	//done when the deadline passes, so workers stop picking up nodes
+	//	and outstanding queries are abandoned
+	concurrency := currentConfig().Concurrency
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(concurrency.RequestDeadlineMs) * time.Millisecond)
+	defer cancel()
+
+	runWorkerPool(ctx, len(nodes), concurrency.NodeQueryWorkers, func(i int) {
+		queryNode(ctx, i, &nodes[i], pod_uid, interfaces_needed, constraints, node_eligibility_channel)
+	})
+
+	//collect results until every node has been checked or the deadline
+	//	passes
+	for received := 0; received < len(nodes); received++ {
+		select {
+		case result := <-node_eligibility_channel:
+			results[result.index] = result
+		case <-ctx.Done():
+			return results
+		}
+	}
+
+	return results
+}
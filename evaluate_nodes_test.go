@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//counting_transport counts the queries to DaemonSets that are in flight,
+//	and remembers the most there ever were at once.
+type counting_transport struct {
+	transport http.RoundTripper
+	in_flight int32
+	most_in_flight int32
+}
+
+func (counting *counting_transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	in_flight := atomic.AddInt32(&counting.in_flight, 1)
+	defer atomic.AddInt32(&counting.in_flight, -1)
+	for {
+		most := atomic.LoadInt32(&counting.most_in_flight)
+		if(in_flight <= most || atomic.CompareAndSwapInt32(&counting.most_in_flight, most, in_flight)) {
+			break
+		}
+	}
+	return counting.transport.RoundTrip(request)
+}
+
+//fakeDaemonSet starts a server that answers for the RDMA hardware DaemonSet
+//	of every node, telling them apart by the host they were queried at.
+//	node 'i' has one PF with a capacity of 1000 + i, and free VFs only if
+//	'i' is even. queries to nodes that 'slow' picks out are never answered.
+func fakeDaemonSet(slow func(int) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		host, _, _ := net.SplitHostPort(request.Host)
+		node_index, err := strconv.Atoi(host[strings.LastIndex(host, "-") + 1:])
+		if(err != nil) {
+			http.Error(response, err.Error(), http.StatusNotFound)
+			return
+		}
+		if(slow(node_index)) {
+			<-request.Context().Done()
+			return
+		}
+
+		pf := rdma_placement.ReportedPF{PF: rdma_hardware_info.PF{
+			Name: "pf0",
+			CapacityTxRate: uint(1000 + node_index),
+			CapacityVFs: 4,
+		}}
+		if(node_index % 2 != 0) {
+			pf.UsedVFs = pf.CapacityVFs
+		}
+		json.NewEncoder(response).Encode([]rdma_placement.ReportedPF{pf})
+	}))
+}
+
+func TestEvaluateNodes(t *testing.T) {
+	never := func(int) bool { return false }
+	cases := []struct {
+		name string
+		nodes int
+		workers int
+		deadline time.Duration
+		slow func(int) bool
+	}{
+		{name: "hundreds of nodes", nodes: 500, workers: 8, deadline: 10 * time.Second, slow: never},
+		{name: "more workers than nodes", nodes: 5, workers: 32, deadline: 10 * time.Second, slow: never},
+		{name: "single worker", nodes: 200, workers: 1, deadline: 10 * time.Second, slow: never},
+		{name: "slow nodes at deadline", nodes: 300, workers: 16, deadline: time.Second, slow: func(i int) bool { return i >= 260 }},
+	}
+
+	previous_config := currentConfig()
+	previous_query := currentNodeQuery()
+	defer active_config.Store(previous_config)
+	defer active_node_query.Store(previous_query)
+
+	for case_index, test_case := range cases {
+		t.Run(test_case.name, func(t *testing.T) {
+			server := fakeDaemonSet(test_case.slow)
+			defer server.Close()
+
+			config := *previous_config
+			config.Concurrency.NodeQueryWorkers = test_case.workers
+			config.Concurrency.RequestDeadlineMs = int(test_case.deadline / time.Millisecond)
+			active_config.Store(&config)
+
+			//every node's address leads to the fake DaemonSet
+			counting := &counting_transport{transport: &http.Transport{
+				DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+				},
+				MaxIdleConnsPerHost: 1,
+			}}
+			active_node_query.Store(&node_query_client{
+				scheme: "http",
+				port: rdma_hardware_info.DefaultPort,
+				client: &http.Client{Transport: counting},
+			})
+
+			nodes := make([]v1.Node, test_case.nodes)
+			for i := range nodes {
+				nodes[i].ObjectMeta = metav1.ObjectMeta{Name: fmt.Sprintf("case%d-node-%d", case_index, i)}
+				nodes[i].Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalDNS, Address: nodes[i].Name}}
+			}
+			interfaces := []knapsack_pod_placement.RdmaInterfaceRequest{{MinTxRate: 100}}
+
+			results := evaluateNodes(context.Background(), nodes, "pod", interfaces, rdma_placement.Constraints{})
+
+			if(len(results) != len(nodes)) {
+				t.Fatalf("got %d results for %d nodes", len(results), len(nodes))
+			}
+			for i, result := range results {
+				if(result.index != i) {
+					t.Errorf("result %d is for node %d", i, result.index)
+				}
+				switch {
+				case test_case.slow(i):
+					if(result.enough_resources || result.ineligibility_cause != ineligibleTimeout) {
+						t.Errorf("node %d was still pending at the deadline but was reported as %+v", i, result)
+					}
+				case i % 2 == 0:
+					if(!result.enough_resources || result.capacity != 1000 + i - 100) {
+						t.Errorf("node %d should fit the pod with capacity %d left, got %+v", i, 1000 + i - 100, result)
+					}
+				default:
+					if(result.enough_resources || result.ineligibility_cause == ineligibleTimeout || result.capacity != 1000 + i) {
+						t.Errorf("node %d has no free VFs and should be turned down with capacity %d, got %+v", i, 1000 + i, result)
+					}
+				}
+			}
+
+			most := int(atomic.LoadInt32(&counting.most_in_flight))
+			if(most > test_case.workers) {
+				t.Errorf("%d queries were in flight at once with a limit of %d workers", most, test_case.workers)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+//reasons of the events the extender emits on pods.
+const (
+	eventReasonFailedRdmaPlacement string = "FailedRdmaPlacement"
+	eventReasonRdmaPlaced string = "RdmaInterfacesPlaced"
+	eventReasonWaitingForRdmaGang string = "WaitingForRdmaGang"
+)
+
+//records events on pods explaining where their RDMA interfaces went. it is
+//	nil if no connection to the k8s API server could be configured. the
+//	event broadcaster deduplicates repeated events on the same pod and
+//	rate-limits events from the extender per pod, so the same outcome
+//	being reported on every scheduling attempt doesn't flood the API
+//	server.
+var event_recorder record.EventRecorder
+
+//newEventRecorder sets up a recorder that sends events to the k8s API
+//	server.
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: RdmaSchedulerExtenderEventSource})
+}
+
+//recordPodEvent records an event on a pod, if events can be recorded.
+func recordPodEvent(pod *v1.Pod, event_type string, reason string, message_format string, args ...interface{}) {
+	if(event_recorder == nil) {
+		return
+	}
+	event_recorder.Eventf(pod, event_type, reason, message_format, args...)
+}
+
+//recordNoNodeFits records an event on a pod that no node could take,
+//	summarising why each of the potential nodes was turned down.
+//	'unchecked' counts the nodes that weren't even checked because
+//	nothing was known about them.
+func recordNoNodeFits(pod *v1.Pod, results []node_eligibility, unchecked int) {
+	total := len(results) + unchecked
+	lacked_vfs := 0
+	lacked_bandwidth := 0
+	lacked_network := 0
+	lacked_numa := 0
+	lacked_pfs := 0
+	not_planned := 0
+	timed_out := 0
+	for _, result := range results {
+		switch result.ineligibility_cause {
+		case ineligibleInsufficientVFs:
+			lacked_vfs++
+		case ineligibleInsufficientBandwidth:
+			lacked_bandwidth++
+		case ineligibleNetwork:
+			lacked_network++
+		case ineligibleNumaAffinity:
+			lacked_numa++
+		case ineligiblePFConstraints:
+			lacked_pfs++
+		case ineligibleGangPlan:
+			not_planned++
+		case ineligibleSolverTimeout:
+			timed_out++
+		default:
+			unchecked++
+		}
+	}
+
+	recordPodEvent(pod, v1.EventTypeWarning, eventReasonFailedRdmaPlacement,
+		"No node can fit the pod's RDMA interfaces: %d of %d nodes lacked free VFs, %d lacked free bandwidth, %d lacked room on the requested networks, %d lacked room on a single NUMA node, %d lacked room on enough separate PFs, %d were not the node planned for the pod's gang, %d ran out of time searching for a placement, %d could not be reached or checked in time.",
+		lacked_vfs, total, lacked_bandwidth, lacked_network, lacked_numa, lacked_pfs, not_planned, timed_out, unchecked)
+}
+
+//recordPlacement records an event on a pod saying which node it was bound
+//	to, and which PFs its RDMA interfaces were placed on.
+func recordPlacement(pod *v1.Pod, node_name string, placement []rdma_interface_placement) {
+	interfaces := make([]string, len(placement))
+	for i, iface := range placement {
+		interfaces[i] = fmt.Sprintf("%s (min_tx_rate %d, max_tx_rate %d)", iface.PF, iface.MinTxRate, iface.MaxTxRate)
+	}
+
+	recordPodEvent(pod, v1.EventTypeNormal, eventReasonRdmaPlaced,
+		"Bound to node %s with RDMA interfaces on PFs: %s", node_name, strings.Join(interfaces, ", "))
+}
+
+//recordGangNotPlaced records an event on a member of a gang explaining why
+//	the gang couldn't be placed yet.
+func recordGangNotPlaced(pod *v1.Pod, err error) {
+	_, incomplete := err.(*gang_incomplete_error)
+	if(incomplete) {
+		recordPodEvent(pod, v1.EventTypeNormal, eventReasonWaitingForRdmaGang, "%s", err.Error())
+		return
+	}
+	recordPodEvent(pod, v1.EventTypeWarning, eventReasonFailedRdmaPlacement, "%s", err.Error())
+}
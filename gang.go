@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+//name of the pod informer index that maps gangs to their member pods.
+const podGangIndex string = "gang"
+
+//error returned when a pod's gang can't be planned because some of its
+//	members haven't been created yet.
+type gang_incomplete_error struct {
+	gang string
+	members int
+	size int
+}
+
+func (err *gang_incomplete_error) Error() string {
+	return fmt.Sprintf("RDMA Scheduler Extension: Waiting for the members of gang %s (%d of %d created).", err.gang, err.members, err.size)
+}
+
+//gangKey returns the key a gang is known by, which is unique across
+//	namespaces.
+func gangKey(namespace string, gang_name string) string {
+	return namespace + "/" + gang_name
+}
+
+//podGang reads which gang a pod belongs to, and how many members the gang
+//	has, from the pod's 'rdma_gang_name' and 'rdma_gang_size' labels. it
+//	returns false if the pod isn't part of a gang.
+func podGang(pod *v1.Pod) (string, int, bool, error) {
+	gang_name, found := pod.ObjectMeta.Labels[RdmaGangNameLabel]
+	if(!found || gang_name == "") {
+		return "", 0, false, nil
+	}
+
+	size_label := pod.ObjectMeta.Labels[RdmaGangSizeLabel]
+	size, err := strconv.Atoi(size_label)
+	if(err != nil || size < 1) {
+		return "", 0, true, fmt.Errorf("invalid %s label '%s', it must be a number no smaller than 1", RdmaGangSizeLabel, size_label)
+	}
+
+	return gangKey(pod.ObjectMeta.Namespace, gang_name), size, true, nil
+}
+
+//indexPodsByGang adds an index on gangs to the pod informer, so that the
+//	members of a gang can be found quickly.
+func indexPodsByGang(informer_factory informers.SharedInformerFactory) error {
+	return informer_factory.Core().V1().Pods().Informer().AddIndexers(cache.Indexers{
+		podGangIndex: func(obj interface{}) ([]string, error) {
+			pod, is_pod := obj.(*v1.Pod)
+			if(!is_pod) {
+				return []string{}, nil
+			}
+			gang_name := pod.ObjectMeta.Labels[RdmaGangNameLabel]
+			if(gang_name == "") {
+				return []string{}, nil
+			}
+			return []string{gangKey(pod.ObjectMeta.Namespace, gang_name)}, nil
+		},
+	})
+}
+
+//gangMembers looks up the live members of a gang in the local pod cache,
+//	leaving out pods that are being deleted or have finished.
+func gangMembers(gang string) ([]*v1.Pod, error) {
+	if(pod_indexer == nil) {
+		return nil, errors.New("RDMA Scheduler Extension: No pod cache is available to look up the members of gang " + gang + ".")
+	}
+
+	objs, err := pod_indexer.ByIndex(podGangIndex, gang)
+	if(err != nil) {
+		return nil, err
+	}
+	members := make([]*v1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if(pod.ObjectMeta.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed) {
+			continue
+		}
+		members = append(members, pod)
+	}
+
+	return members, nil
+}
+
+//structure describing where every unbound member of a gang was planned to
+//	go. the members' resources are reserved on those nodes until the plan
+//	expires.
+type gang_plan struct {
+	//maps the UID of each member to the node it was planned on
+	nodes map[types.UID]string
+	expires time.Time
+}
+
+//gang_planner keeps track of the plans made for gangs, keyed by gang.
+//	plans are simulated without holding the planner's lock. the lock
+//	only guards storing a plan and reserving its resources, which is
+//	done only if no other plan was stored while the plan was simulated,
+//	so that two gangs are never planned against the same free resources.
+type gang_planner struct {
+	lock sync.Mutex
+	plans map[string]*gang_plan
+	//counts the plans stored and dropped, so that a plan simulated
+	//	while another was stored can be told apart and made again.
+	generation uint64
+}
+
+//the planner shared by all scheduler extender verbs.
+var gangs = &gang_planner{plans: make(map[string]*gang_plan)}
+
+//plannedNode returns the node a pod was planned to go on, if its gang has
+//	a current plan that includes it. callers must hold the planner's
+//	lock.
+func (planner *gang_planner) plannedNode(pod *v1.Pod, gang string) (string, bool) {
+	plan := planner.plans[gang]
+	if(plan == nil || !time.Now().Before(plan.expires)) {
+		return "", false
+	}
+	node_name, found := plan.nodes[pod.ObjectMeta.UID]
+	return node_name, found
+}
+
+//nodeFor returns the node a member of a gang was planned to go on. if the
+//	gang has no current plan that includes the pod, a new plan is made
+//	for all of the gang's unbound members at once, across the potential
+//	nodes the scheduler sent for the pod. this fails if some members
+//	haven't been created yet, if the members can't all be placed, or if
+//	planning them runs out of time.
+func (planner *gang_planner) nodeFor(ctx context.Context, pod *v1.Pod, gang string, size int, nodes []v1.Node) (string, error) {
+	//every attempt at planning the gang shares one solver budget, cut
+	//	short if the request's deadline comes first
+	solver := placementSolver().FirstFit().SharedBudget()
+	if ctx_deadline, has_deadline := ctx.Deadline(); has_deadline && (solver.Deadline.IsZero() || ctx_deadline.Before(solver.Deadline)) {
+		solver.Deadline = ctx_deadline
+	}
+
+	for {
+		planner.lock.Lock()
+		node_name, found := planner.plannedNode(pod, gang)
+		generation := planner.generation
+		planner.lock.Unlock()
+		if(found) {
+			return node_name, nil
+		}
+
+		members, err := gangMembers(gang)
+		if(err != nil) {
+			return "", err
+		}
+		if(len(members) < size) {
+			return "", &gang_incomplete_error{gang: gang, members: len(members), size: size}
+		}
+
+		plan, placements, err := planGang(ctx, gang, members, gangNodes(ctx, nodes), solver)
+
+		planner.lock.Lock()
+		//another member of the gang may have planned it in the
+		//	meantime
+		node_name, found = planner.plannedNode(pod, gang)
+		if(found) {
+			planner.lock.Unlock()
+			return node_name, nil
+		}
+		//if another plan was stored or dropped in the meantime, the
+		//	free resources may have changed, so plan again
+		if(planner.generation != generation) {
+			planner.lock.Unlock()
+			continue
+		}
+		if(err != nil) {
+			planner.dropLocked(gang, members)
+			planner.lock.Unlock()
+			return "", err
+		}
+		planner.storeLocked(gang, plan, placements)
+		planner.lock.Unlock()
+
+		node_name, found = plan.nodes[pod.ObjectMeta.UID]
+		if(!found) {
+			return "", errors.New("RDMA Scheduler Extension: Pod was not found among the members of gang " + gang + ".")
+		}
+		return node_name, nil
+	}
+}
+
+//storeLocked records the plan made for a gang, and reserves the resources
+//	planned for its members, replacing any they held under an earlier
+//	plan. callers must hold the planner's lock.
+func (planner *gang_planner) storeLocked(gang string, plan *gang_plan, placements []gang_member_placement) {
+	for _, member := range placements {
+		if(len(member.placement) > 0) {
+			reservations.reserve(member.pod_uid, member.node_name, member.reported_pfs, member.placement)
+		} else {
+			reservations.release(member.pod_uid)
+		}
+		log.Println("Planned member ", member.pod_name, " of gang ", gang, " on node ", member.node_name)
+	}
+	planner.plans[gang] = plan
+	planner.generation++
+}
+
+//dropLocked drops the plan made for a gang after planning it again failed,
+//	and gives back the resources reserved for its unbound members, so
+//	that the gang doesn't hold resources for only some of them. callers
+//	must hold the planner's lock.
+func (planner *gang_planner) dropLocked(gang string, members []*v1.Pod) {
+	for _, member := range members {
+		if(member.Spec.NodeName == "") {
+			reservations.release(member.ObjectMeta.UID)
+		}
+	}
+	delete(planner.plans, gang)
+	planner.generation++
+}
+
+//forget drops the plan made for a gang, for example because one of its
+//	members was deleted.
+func (planner *gang_planner) forget(gang string) {
+	planner.lock.Lock()
+	defer planner.lock.Unlock()
+
+	delete(planner.plans, gang)
+	planner.generation++
+}
+
+//structure describing the simulated state of one node while a gang is
+//	being planned.
+type gang_node struct {
+	name string
+	reported_pfs []rdma_placement.ReportedPF
+	pfs []rdma_placement.PF
+	policy rdma_placement.BandwidthPolicy
+}
+
+//gangNodes looks up the resources on each of the nodes a gang may be
+//	planned on, as of the inventory snapshot. nodes whose resources are
+//	unknown are left out. reservations are not taken into account yet,
+//	since they may change before the gang is planned.
+func gangNodes(ctx context.Context, nodes []v1.Node) []gang_node {
+	gang_nodes := make([]gang_node, 0, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		reported_pfs, err := getNodePFs(ctx, node.Name, node.Status.Addresses)
+		if(err != nil) {
+			continue
+		}
+		pfs := rdma_placement.FromReported(reported_pfs)
+		labelPFNetworks(node, pfs)
+		gang_nodes = append(gang_nodes, gang_node{
+			name: node.Name,
+			reported_pfs: reported_pfs,
+			pfs: pfs,
+			policy: bandwidthPolicyForNode(node),
+		})
+	}
+	return gang_nodes
+}
+
+//structure describing a node one member of a gang fits on while the gang is
+//	being planned.
+type gang_candidate struct {
+	node int
+	score float64
+	pfs []rdma_placement.PF
+	placements []int
+}
+
+//bestGangCandidate picks the node a member of a gang should go on, the one
+//	the scoring policy likes best. when a fabric topology is loaded, how
+//	close each node is to the nodes the gang's other members are on is
+//	blended in, in the proportion set by the topology weight.
+func bestGangCandidate(candidates []gang_candidate, nodes []gang_node, peer_nodes []string) int {
+	min_score := candidates[0].score
+	max_score := candidates[0].score
+	for _, candidate := range candidates {
+		min_score = math.Min(min_score, candidate.score)
+		max_score = math.Max(max_score, candidate.score)
+	}
+
+	weight := topologyWeight(peer_nodes)
+	best := -1
+	var best_score float64
+	for i, candidate := range candidates {
+		score := candidate.score
+		if(weight > 0) {
+			scaled := 1.0
+			if(max_score > min_score) {
+				scaled = (candidate.score - min_score) / (max_score - min_score)
+			}
+			score = (1 - weight) * scaled + weight * topologyCloseness(nodes[candidate.node].name, peer_nodes)
+		}
+		if(best < 0 || score > best_score) {
+			best = i
+			best_score = score
+		}
+	}
+
+	return best
+}
+
+//structure describing one unbound member of a gang while it's being planned.
+type gang_member struct {
+	pod *v1.Pod
+	interfaces []knapsack_pod_placement.RdmaInterfaceRequest
+	constraints rdma_placement.Constraints
+}
+
+//structure describing the resources one unbound member of a gang was
+//	planned to take on its node, which are reserved once the plan is
+//	stored.
+type gang_member_placement struct {
+	pod_uid types.UID
+	pod_name string
+	node_name string
+	reported_pfs []rdma_placement.ReportedPF
+	placement []rdma_interface_placement
+}
+
+//planGang simulates placing every unbound member of a gang across the
+//	specified nodes, largest requests first, putting each member on the
+//	node the scoring policy (and the fabric topology) likes best. only
+//	whether a member fits on a node is searched for, and all of the
+//	searches share the solver's budget. if every member fits, the plan is
+//	returned along with the resources to reserve for each member.
+//	members that are already bound hold their resources already, and the
+//	resources reserved for the others under an earlier plan are counted
+//	as free. nothing is reserved here.
+func planGang(ctx context.Context, gang string, pods []*v1.Pod, gang_nodes []gang_node, solver rdma_placement.Solver) (*gang_plan, []gang_member_placement, error) {
+	//find the members that still need to be placed, along with their
+	//	RDMA interface requests
+	members := make([]gang_member, 0, len(pods))
+	member_uids := make([]types.UID, 0, len(pods))
+	var peer_nodes []string
+	for _, pod := range pods {
+		if(pod.Spec.NodeName != "") {
+			peer_nodes = append(peer_nodes, pod.Spec.NodeName)
+			continue
+		}
+		interfaces, constraints, err := parseRdmaInterfacesRequired(pod)
+		if(err != nil) {
+			return nil, nil, fmt.Errorf("RDMA Scheduler Extension: member %s of gang %s has an invalid RDMA resources request: %v", pod.ObjectMeta.Name, gang, err)
+		}
+		members = append(members, gang_member{pod: pod, interfaces: interfaces, constraints: constraints})
+		member_uids = append(member_uids, pod.ObjectMeta.UID)
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if(len(members[i].interfaces) != len(members[j].interfaces)) {
+			return len(members[i].interfaces) > len(members[j].interfaces)
+		}
+		return members[i].pod.ObjectMeta.Name < members[j].pod.ObjectMeta.Name
+	})
+
+	//work out what is free on each node, taking into account what
+	//	is reserved for pods other than the members
+	nodes := make([]gang_node, len(gang_nodes))
+	for i, node := range gang_nodes {
+		nodes[i] = node
+		nodes[i].pfs = append([]rdma_placement.PF(nil), node.pfs...)
+		reservations.applyTo(node.name, nodes[i].pfs, member_uids...)
+	}
+
+	//place each member on the best node it fits on, keeping track of
+	//	what it takes there
+	policy := scoring_policies[currentConfig().Placement.ScoringPolicy]
+	chosen_nodes := make([]int, len(members))
+	placements := make([][]rdma_interface_placement, len(members))
+	for i, member := range members {
+		var candidates []gang_candidate
+		for j := range nodes {
+			if(ctx.Err() != nil) {
+				return nil, nil, fmt.Errorf("RDMA Scheduler Extension: Gave up planning gang %s: %v", gang, ctx.Err())
+			}
+			pfs := append([]rdma_placement.PF(nil), nodes[j].pfs...)
+			capacity, pf_indices, placement_success, placement_err := rdma_placement.PlacePodWithConstraints(member.interfaces, pfs, nodes[j].policy, member.constraints, solver, false)
+			if(placement_err == rdma_placement.ErrSolverTimedOut) {
+				return nil, nil, fmt.Errorf("RDMA Scheduler Extension: Ran out of time planning the %d unbound members of gang %s (solver budget: %s).", len(members), gang, solver.Budget)
+			}
+			if(!placement_success) {
+				continue
+			}
+			score := policy(node_eligibility{
+				capacity: capacity,
+				enough_resources: true,
+				pfs: pfs,
+				placements: pf_indices,
+				policy: nodes[j].policy,
+			})
+			candidates = append(candidates, gang_candidate{node: j, score: score, pfs: pfs, placements: pf_indices})
+		}
+		if(len(candidates) == 0) {
+			return nil, nil, fmt.Errorf("RDMA Scheduler Extension: The %d unbound members of gang %s can't all be placed at once (member %s doesn't fit).", len(members), gang, member.pod.ObjectMeta.Name)
+		}
+
+		best := candidates[bestGangCandidate(candidates, nodes, peer_nodes)]
+		best_pfs := best.pfs
+		nodes[best.node].pfs = best_pfs
+		chosen_nodes[i] = best.node
+		peer_nodes = append(peer_nodes, nodes[best.node].name)
+		placements[i] = make([]rdma_interface_placement, len(member.interfaces))
+		for k, pf_index := range best.placements {
+			placements[i][k].PF = best_pfs[pf_index].Name
+			placements[i][k].MinTxRate = member.interfaces[k].MinTxRate
+			placements[i][k].MaxTxRate = member.interfaces[k].MaxTxRate
+			placements[i][k].NumaNode = best_pfs[pf_index].NumaNode
+			placements[i][k].Network = best_pfs[pf_index].Network
+		}
+	}
+
+	//every member fits, so the plan can be stored
+	ttl := time.Duration(currentConfig().Cache.ReservationTTLSeconds) * time.Second
+	plan := &gang_plan{
+		nodes: make(map[types.UID]string),
+		expires: time.Now().Add(ttl),
+	}
+	member_placements := make([]gang_member_placement, len(members))
+	for i, member := range members {
+		node := &nodes[chosen_nodes[i]]
+		plan.nodes[member.pod.ObjectMeta.UID] = node.name
+		member_placements[i] = gang_member_placement{
+			pod_uid: member.pod.ObjectMeta.UID,
+			pod_name: member.pod.ObjectMeta.Name,
+			node_name: node.name,
+			reported_pfs: node.reported_pfs,
+			placement: placements[i],
+		}
+	}
+
+	return plan, member_placements, nil
+}
+
+//gangPlanReason describes why a node other than the one planned for a
+//	member of a gang was turned down.
+func gangPlanReason(planned_node string) string {
+	return "RDMA Scheduler Extension: Node is not the one planned for the pod's gang (" + planned_node + ")."
+}
+
+//plannedGangNode returns the node a pod was planned to go on as part of its
+//	gang, planning the gang across the pod's potential nodes if needed.
+//	it returns an empty node name if the pod isn't part of a gang.
+func plannedGangNode(ctx context.Context, pod *v1.Pod, nodes []v1.Node) (string, error) {
+	gang, size, in_gang, err := podGang(pod)
+	if(err != nil) {
+		return "", errors.New("RDMA Scheduler Extension: " + err.Error())
+	}
+	if(!in_gang) {
+		return "", nil
+	}
+
+	return gangs.nodeFor(ctx, pod, gang, size, nodes)
+}
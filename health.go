@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+
+	"k8s.io/api/core/v1"
+)
+
+//build information, filled in at build time with
+//	-ldflags "-X main.build_version=... -X main.build_commit=... -X main.build_date=..."
+var (
+	build_version string = "unknown"
+	build_commit string = "unknown"
+	build_date string = "unknown"
+)
+
+//set to 1 once the extender starts shutting down, after which it reports
+//	itself as not ready.
+var shutting_down int32
+
+//set to 1 once a query to the RDMA hardware DaemonSet on any node succeeds.
+var daemonset_reached int32
+
+//structure naming a node whose DaemonSet can be queried by readiness probes.
+type daemonset_probe_target struct {
+	node_name string
+	node_addresses []v1.NodeAddress
+}
+
+//the last node whose DaemonSet was queried directly. always holds a
+//	daemonset_probe_target once a node has been queried.
+var last_queried_node atomic.Value
+
+//structure describing the response to a version request.
+type version_info struct {
+	Version string `json:"version"`
+	Commit string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	//maps the name of each supported scheduler extender verb to the
+	//	path it is served on
+	Verbs map[string]string `json:"verbs"`
+}
+
+//inventoryReachable determines whether the source of RDMA inventory can
+//	reach at least one DaemonSet. while the background poller is running
+//	that means some node has a recent snapshot. otherwise, until some
+//	DaemonSet has answered a query, the DaemonSet of the last node the
+//	extender was asked about is queried. without the poller the extender
+//	only learns of nodes from the scheduling requests it is sent, so
+//	before it has been sent any it counts as reachable. failing then would
+//	keep a Service from ever sending it those requests.
+func inventoryReachable(ctx context.Context) bool {
+	if(inventory.isPolling()) {
+		return inventory.hasFreshSnapshot()
+	}
+	if(atomic.LoadInt32(&daemonset_reached) == 1) {
+		return true
+	}
+
+	target, known := last_queried_node.Load().(daemonset_probe_target)
+	if(!known) {
+		return true
+	}
+	_, err := fetchNodePFs(ctx, target.node_name, target.node_addresses)
+	return err == nil
+}
+
+// HandleHealthzRequest is a callback function that answers liveness probes.
+func HandleHealthzRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	response.Write([]byte("ok"))
+}
+
+// HandleReadyzRequest is a callback function that answers readiness probes.
+//	the extender is ready once it can reach at least one DaemonSet, until
+//	it starts shutting down. a DaemonSet queried to find that out is
+//	given up on when the probe is.
+func HandleReadyzRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	if(atomic.LoadInt32(&shutting_down) == 1) {
+		http.Error(response, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if(!inventoryReachable(request.Context())) {
+		http.Error(response, "no RDMA hardware DaemonSet reachable", http.StatusServiceUnavailable)
+		return
+	}
+	response.Write([]byte("ok"))
+}
+
+// HandleVersionRequest is a callback function that reports the build of the
+//	extender, and the scheduler extender verbs it supports.
+func HandleVersionRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	server := currentConfig().Server
+	response_body, err := json.Marshal(version_info{
+		Version: build_version,
+		Commit: build_commit,
+		BuildDate: build_date,
+		GoVersion: runtime.Version(),
+		Verbs: map[string]string{
+			"filter": server.FilterPath,
+			"prioritize": server.PrioritizePath,
+			"bind": server.BindPath,
+			"preempt": server.PreemptPath,
+		},
+	})
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
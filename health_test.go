@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestReadyzQueriesDaemonSetWithoutPoller(t *testing.T) {
+	//nodes from 'readyz-node-1' on never answer
+	server := fakeDaemonSet(func(i int) bool { return i >= 1 })
+	defer server.Close()
+
+	previous_query := currentNodeQuery()
+	defer active_node_query.Store(previous_query)
+	active_node_query.Store(&node_query_client{
+		scheme: "http",
+		port: rdma_hardware_info.DefaultPort,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+			},
+		}},
+	})
+	defer atomic.StoreInt32(&daemonset_reached, atomic.LoadInt32(&daemonset_reached))
+
+	probe := func(node_name string) int {
+		atomic.StoreInt32(&daemonset_reached, 0)
+		last_queried_node.Store(daemonset_probe_target{
+			node_name: node_name,
+			node_addresses: []v1.NodeAddress{{Type: v1.NodeInternalDNS, Address: node_name}},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 500 * time.Millisecond)
+		defer cancel()
+		request := httptest.NewRequest("GET", RdmaSchedulerExtenderReadyzPath, nil).WithContext(ctx)
+		response := httptest.NewRecorder()
+		HandleReadyzRequest(response, request, nil)
+		return response.Code
+	}
+
+	if code := probe("readyz-node-1"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while the DaemonSet doesn't answer, got status %d", code)
+	}
+	if code := probe("readyz-node-0"); code != http.StatusOK {
+		t.Errorf("expected ready once the DaemonSet answers, got status %d", code)
+	}
+	if(atomic.LoadInt32(&daemonset_reached) != 1) {
+		t.Error("expected the answer to the probe's query to count as reaching a DaemonSet")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+var (
+	errNodeUnreachable = errors.New("RDMA Scheduler Extension: Unable to collect information on available RDMA resources for node.")
+	errCircuitOpen = errors.New("RDMA Scheduler Extension: Skipped node because its RDMA hardware DaemonSet keeps failing (circuit open).")
+	errInventoryStale = errors.New("RDMA Scheduler Extension: Information on available RDMA resources for node is missing or out of date.")
+)
+
+//structure holding the PFs a node's DaemonSet reported the last time it was
+//	successfully polled.
+type node_inventory struct {
+	pfs []rdma_placement.ReportedPF
+	updated time.Time
+}
+
+//inventory_cache holds a snapshot of the RDMA resources on every node, kept
+//	up to date by a background poller so that scheduling requests don't
+//	have to wait on the DaemonSets.
+type inventory_cache struct {
+	lock sync.RWMutex
+	nodes map[string]node_inventory
+	//snapshots older than this are not used for scheduling
+	max_age time.Duration
+	//set to 1 while the background poller is running. if it isn't,
+	//	requests fall back to querying the DaemonSets directly.
+	polling int32
+}
+
+//the inventory shared by all scheduler extender verbs.
+var inventory = newInventoryCache(RdmaSchedulerDefaultInventoryMaxAge)
+
+//newInventoryCache creates an empty inventory whose snapshots are considered
+//	stale after the specified amount of time.
+func newInventoryCache(max_age time.Duration) *inventory_cache {
+	return &inventory_cache{
+		nodes: make(map[string]node_inventory),
+		max_age: max_age,
+	}
+}
+
+//get returns a copy of the latest snapshot of a node's PFs, and whether a
+//	recent enough snapshot was available.
+func (inventory *inventory_cache) get(node_name string) ([]rdma_placement.ReportedPF, bool) {
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+
+	snapshot, found := inventory.nodes[node_name]
+	if(!found || time.Since(snapshot.updated) > inventory.max_age) {
+		return nil, false
+	}
+
+	return append([]rdma_placement.ReportedPF(nil), snapshot.pfs...), true
+}
+
+//set records a new snapshot of a node's PFs.
+func (inventory *inventory_cache) set(node_name string, pfs []rdma_placement.ReportedPF) {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+
+	forgetSnapshot(node_name, inventory.nodes[node_name].pfs)
+	recordSnapshot(node_name, pfs)
+	inventory.nodes[node_name] = node_inventory{
+		pfs: pfs,
+		updated: time.Now(),
+	}
+}
+
+//retain drops the snapshots of all nodes not in the specified set.
+func (inventory *inventory_cache) retain(node_names map[string]bool) {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+
+	for node_name := range inventory.nodes {
+		if(!node_names[node_name]) {
+			forgetSnapshot(node_name, inventory.nodes[node_name].pfs)
+			delete(inventory.nodes, node_name)
+		}
+	}
+}
+
+//setMaxAge changes how old a snapshot may get before it's no longer used.
+func (inventory *inventory_cache) setMaxAge(max_age time.Duration) {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+
+	inventory.max_age = max_age
+}
+
+//startPolling records that the background poller is running.
+func (inventory *inventory_cache) startPolling() {
+	atomic.StoreInt32(&inventory.polling, 1)
+}
+
+//isPolling determines whether the background poller is running.
+func (inventory *inventory_cache) isPolling() bool {
+	return atomic.LoadInt32(&inventory.polling) == 1
+}
+
+//hasFreshSnapshot determines whether any node has a recent enough snapshot
+//	to be used for scheduling.
+func (inventory *inventory_cache) hasFreshSnapshot() bool {
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+
+	for _, snapshot := range inventory.nodes {
+		if(time.Since(snapshot.updated) <= inventory.max_age) {
+			return true
+		}
+	}
+	return false
+}
+
+//fetchNodePFs queries the RDMA hardware DaemonSet on a node for the PFs it
+//	has, trying each of the node's internal addresses (those reachable
+//	from within the k8s cluster) until one of them answers. if none of
+//	them do, all of the addresses are tried again after a jittered
+//	backoff, up to the configured number of retries or until the context
+//	is done. nodes whose circuit is open are skipped without a query.
+func fetchNodePFs(ctx context.Context, node_name string, node_addresses []v1.NodeAddress) ([]rdma_placement.ReportedPF, error) {
+	last_queried_node.Store(daemonset_probe_target{
+		node_name: node_name,
+		node_addresses: node_addresses,
+	})
+	if(!node_breaker.allow(node_name)) {
+		return nil, errCircuitOpen
+	}
+
+	//the same client is used for every attempt, even if the settings
+	//	change in the meantime
+	node_query := currentNodeQuery()
+
+	var last_err error = errNodeUnreachable
+	for attempt := 0; attempt <= node_query.retries; attempt++ {
+		if(attempt > 0) {
+			select {
+			case <-ctx.Done():
+				node_breaker.abandoned(node_name)
+				return nil, errNodeUnreachable
+			case <-time.After(node_query.retryBackoff(attempt)):
+			}
+		}
+
+		for _, node_addr := range node_addresses {
+			if((node_addr.Type == v1.NodeInternalIP) || (node_addr.Type == v1.NodeInternalDNS)) {
+				start := time.Now()
+				pfs, err := node_query.queryNode(ctx, node_addr.Address)
+				recordNodeQuery(node_name, start, err)
+				//if an error occured while querying the node, try
+				//	the next address, unless the query was
+				//	cancelled
+				if(err != nil) {
+					last_err = err
+					if(ctx.Err() != nil) {
+						node_breaker.abandoned(node_name)
+						return nil, errNodeUnreachable
+					}
+					continue
+				}
+				node_breaker.succeeded(node_name)
+				atomic.StoreInt32(&daemonset_reached, 1)
+				return pfs, nil
+			}
+		}
+	}
+
+	log.Println("Unable to query RDMA hardware DaemonSet on node ", node_name, ": ", last_err)
+	node_breaker.failed(node_name)
+	return nil, errNodeUnreachable
+}
+
+//getNodePFs returns the PFs available on a node. while the background poller
+//	is running they come from its latest snapshot, otherwise the node's
+//	DaemonSet is queried directly.
+func getNodePFs(ctx context.Context, node_name string, node_addresses []v1.NodeAddress) ([]rdma_placement.ReportedPF, error) {
+	if(inventory.isPolling()) {
+		pfs, fresh := inventory.get(node_name)
+		if(!fresh) {
+			return nil, errInventoryStale
+		}
+		return pfs, nil
+	}
+
+	return fetchNodePFs(ctx, node_name, node_addresses)
+}
+
+//refreshInventory queries the DaemonSet on every node in the node cache, and
+//	records what they report in the inventory. the nodes are queried by a
+//	pool of no more than 'nodeQueryWorkers' workers.
+func refreshInventory(ctx context.Context, inventory *inventory_cache, nodes corelisters.NodeLister) {
+	node_list, err := nodes.List(labels.Everything())
+	if(err != nil) {
+		log.Println("Unable to list nodes to poll for RDMA resources: ", err)
+		return
+	}
+
+	current_nodes := make(map[string]bool)
+	for _, node := range node_list {
+		current_nodes[node.Name] = true
+	}
+
+	workers := currentConfig().Concurrency.NodeQueryWorkers
+	<-runWorkerPool(ctx, len(node_list), workers, func(i int) {
+		node := node_list[i]
+		pfs, err := fetchNodePFs(ctx, node.Name, node.Status.Addresses)
+		//nodes that can't be reached keep their old snapshot
+		//	until it goes stale
+		if(err != nil) {
+			return
+		}
+		inventory.set(node.Name, pfs)
+	})
+
+	//forget about nodes that have left the cluster
+	inventory.retain(current_nodes)
+}
+
+//pollInventory refreshes the inventory each poll interval, until the context
+//	is cancelled. the interval is looked up again before each wait, so
+//	changes to it take effect without restarting the poller.
+func pollInventory(ctx context.Context, inventory *inventory_cache, nodes corelisters.NodeLister) {
+	for {
+		interval := time.Duration(currentConfig().Cache.InventoryPollIntervalMs) * time.Millisecond
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		refreshInventory(ctx, inventory, nodes)
+	}
+}
+
+//largestCapacities returns the bandwidth of the largest PF, and the most VFs
+//	on a single node, among the nodes with a recent snapshot. it returns
+//	false if there are no such nodes to go on.
+func (inventory *inventory_cache) largestCapacities() (uint, uint, bool) {
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+
+	var largest_tx_rate uint = 0
+	var most_vfs uint = 0
+	var known bool = false
+	for _, snapshot := range inventory.nodes {
+		if(time.Since(snapshot.updated) > inventory.max_age) {
+			continue
+		}
+		known = true
+
+		var node_vfs uint = 0
+		for _, pf := range snapshot.pfs {
+			node_vfs += pf.CapacityVFs
+			if(pf.CapacityTxRate > largest_tx_rate) {
+				largest_tx_rate = pf.CapacityTxRate
+			}
+		}
+		if(node_vfs > most_vfs) {
+			most_vfs = node_vfs
+		}
+	}
+
+	return largest_tx_rate, most_vfs, known
+}
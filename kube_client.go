@@ -0,0 +1,33 @@
+package main
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+//the client used to talk to the k8s API server. this is nil if no
+//	connection to the API server could be configured, in which case
+//	only the verbs that don't need one are usable.
+var kube_client kubernetes.Interface
+
+//getKubeClient builds a client for the k8s API server. if the 'KUBECONFIG'
+//	environment variable names a kubeconfig file, that is used (handy
+//	when running outside of the cluster), otherwise the service account
+//	of the pod the extender is running in is used.
+func getKubeClient() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+
+	kubeconfig_path := getEnvVar("KUBECONFIG", "")
+	if(kubeconfig_path != "") {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig_path)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if(err != nil) {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
@@ -2,44 +2,117 @@ package main
 
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
-	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
 )
 
 const (
 	RdmaSchedulerExtenderDefaultPort string = "8888"
 	RdmaSchedulerExtenderHttpListenPath string = "/scheduler/rdma_scheduling"
-	RdmaSchedulerNodeQueryTimeout int = 1500
+	RdmaSchedulerExtenderPrioritizePath string = "/scheduler/rdma_prioritize"
+	RdmaSchedulerExtenderBindPath string = "/scheduler/rdma_bind"
+	RdmaSchedulerExtenderPreemptPath string = "/scheduler/rdma_preempt"
+	RdmaSchedulerExtenderMetricsPath string = "/metrics"
+	RdmaSchedulerExtenderHealthzPath string = "/healthz"
+	RdmaSchedulerExtenderReadyzPath string = "/readyz"
+	RdmaSchedulerExtenderVersionPath string = "/version"
+	RdmaSchedulerExtenderConfigPath string = "/debug/config"
+	RdmaSchedulerDefaultNodeQueryTimeout int = 1500
+	RdmaSchedulerDefaultScoringPolicy string = "bin-pack"
+	RdmaSchedulerDefaultReservationTTL time.Duration = 60 * time.Second
+	RdmaSchedulerDefaultInventoryPollInterval time.Duration = 2 * time.Second
+	RdmaSchedulerDefaultInventoryMaxAge time.Duration = 10 * time.Second
+	RdmaSchedulerDefaultNodeQueryWorkers int = 32
+	RdmaSchedulerDefaultRequestDeadline time.Duration = 4 * time.Second
+	RdmaSchedulerDefaultAuditLogMaxSizeMB int = 100
+	RdmaSchedulerDefaultAuditLogMaxBackups int = 5
+	RdmaSchedulerDefaultDrainTimeout time.Duration = 20 * time.Second
+	RdmaSchedulerDefaultShutdownGrace time.Duration = 5 * time.Second
+	RdmaSchedulerDefaultNodeQueryRetries int = 2
+	RdmaSchedulerDefaultNodeQueryRetryBackoff time.Duration = 100 * time.Millisecond
+	RdmaSchedulerDefaultCircuitBreakerFailures int = 3
+	RdmaSchedulerDefaultCircuitBreakerCooldown time.Duration = 30 * time.Second
+	RdmaSchedulerConfigFileCheckInterval time.Duration = 5 * time.Second
+	RdmaSchedulerTopologyCheckInterval time.Duration = 15 * time.Second
+	RdmaSchedulerDefaultTopologyConfigMapKey string = "topology.yaml"
+	RdmaSchedulerDefaultTopologyWeight float64 = 0.5
+	RdmaSchedulerDefaultTopologyLeafWeight int = 2
+	RdmaSchedulerDefaultTopologyRailWeight int = 1
+	RdmaInterfacesRequiredAnnotation string = "rdma_interfaces_required"
+	RdmaInterfacesRequestApiVersion string = "rit-k8s-rdma/v1"
+	RdmaMaxInterfacesPerPod uint = 32
+	RdmaInterfacePlacementAnnotation string = "rdma_interface_placement"
+	RdmaGangNameLabel string = "rdma_gang_name"
+	RdmaGangSizeLabel string = "rdma_gang_size"
+	RdmaJobLabel string = "rdma_job"
+	RdmaBandwidthModeLabel string = "rdma_bandwidth_mode"
+	RdmaOversubscriptionRatioLabel string = "rdma_oversubscription_ratio"
+	RdmaPFNetworkLabelPrefix string = "rdma_network."
+	RdmaSchedulerDefaultBandwidthMode rdma_placement.BandwidthMode = rdma_placement.BurstableMode
+	RdmaSchedulerDefaultOversubscriptionRatio float64 = 2
+	RdmaSchedulerDefaultSolverGoal rdma_placement.PlacementGoal = rdma_placement.FirstFitGoal
+	RdmaSchedulerDefaultSolverBudget time.Duration = 50 * time.Millisecond
+	RdmaWebhookDefaultPort string = "8443"
+	RdmaWebhookValidatePath string = "/webhook/validate"
+	RdmaWebhookMutatePath string = "/webhook/mutate"
+	RdmaExtenderManagedResource v1.ResourceName = "rit-k8s-rdma/interfaces"
+	RdmaSchedulerExtenderEventSource string = "rdma-scheduler-extender"
 )
 
 //structure describing whether or not a pod can be scheduled on a specific
 //	node. this type is passed through the channel from 'queryNode' to
-//	'HandleSchedulerFilterRequest'
+//	'evaluateNodes'
 type node_eligibility struct {
 	capacity int
 	index int
 	enough_resources bool
 	ineligibility_reason string
+	//why the node can't take the pod, one of the 'ineligible...'
+	//	constants. empty when 'enough_resources' is true.
+	ineligibility_cause string
+	//the node's PFs as the DaemonSet reported them, before any
+	//	reservations or the pod's interfaces were accounted for.
+	reported_pfs []rdma_placement.ReportedPF
+	//the node's PFs as they would look after the pod's interfaces
+	//	were placed on them, and which PF each interface went to.
+	//	these are only filled in when 'enough_resources' is true.
+	pfs []rdma_placement.PF
+	placements []int
+	//the bandwidth policy the pod's interfaces were placed under.
+	policy rdma_placement.BandwidthPolicy
 }
 
-//queryNode takes in the address(es) of a single potential node, a list of the
-//	RDMA resources needed by a pod, and a channel to send the results back
-//	in. it queries the RDMA hardware DaemonSet on the potential node, then
-//	determines if that node's resources are enough to satisfy the pod's
-//	request. the result is then passed back through the channel.
-func queryNode(node_index int,
-	node_addresses []v1.NodeAddress,
+//queryNode takes in a single potential node, a list of the RDMA resources
+//	needed by a pod, and a channel to send the results back in. it looks
+//	up the RDMA resources available on the potential node, then determines
+//	if that node's resources (minus those reserved for other recently bound
+//	pods) are enough to satisfy the pod's request under the node's
+//	bandwidth policy. the result is then passed back through the channel.
+//	if the context is cancelled, any query to the node's DaemonSet is
+//	abandoned.
+func queryNode(ctx context.Context,
+	node_index int,
+	node *v1.Node,
+	pod_uid types.UID,
 	needed_resources []knapsack_pod_placement.RdmaInterfaceRequest,
+	constraints rdma_placement.Constraints,
 	output_channel chan<- node_eligibility) {
 
 	//set up the result structure and fill initialize it with an id of the
@@ -47,50 +120,98 @@ func queryNode(node_index int,
 	var node_result node_eligibility
 	node_result.index = node_index
 
-	//iterate through the node's internal addresses (those reachable from
-	//	within the k8s cluster) until we find one at which we can
-	//	reach the node.
-	for _, node_addr := range node_addresses {
-		if((node_addr.Type == v1.NodeInternalIP) || (node_addr.Type == v1.NodeInternalDNS)) {
-			//query the node for what RDMA resources it has available
-			pfs, err := rdma_hardware_info.QueryNode(node_addr.Address, rdma_hardware_info.DefaultPort, RdmaSchedulerNodeQueryTimeout)
-			//if an error occured while querying the node, try the next address
-			if(err != nil) {
-				continue
-			}
+	//get the RDMA resources the node has available
+	pfs, err := getNodePFs(ctx, node.Name, node.Status.Addresses)
+	//if we couldn't find out, return a result stating that.
+	if(err != nil) {
+		node_result.ineligibility_cause = ineligibleUnreachable
+		if(err == errInventoryStale) {
+			node_result.ineligibility_cause = ineligibleStale
+		} else if(err == errCircuitOpen) {
+			node_result.ineligibility_cause = ineligibleCircuitOpen
+		}
+		node_result.enough_resources = false
+		node_result.ineligibility_reason = err.Error()
+		output_channel <- node_result
+		return
+	}
 
-			//determine if the node's avilable resources will satisfy the pod's needs
-			capacity,_, placement_success := knapsack_pod_placement.PlacePod(needed_resources, pfs, false)
-
-			//if the pod's needs couldn't be met
-			if(!placement_success) {
-				//report that back through the channel
-				node_result.enough_resources = false
-				node_result.ineligibility_reason = "RDMA Scheduler Extension: Node did not have enough free RDMA resources."
-				node_result.capacity = capacity
-				output_channel <- node_result
-				return
-			//otherwise, the pod's needs could be met
-			} else {
-				//report that back through the channel
-				node_result.enough_resources = true
-				node_result.ineligibility_reason = ""
-				node_result.capacity = capacity
-				output_channel <- node_result
-				return
+	//keep a copy of what the DaemonSet reported, then take into account
+	//	resources handed out to pods that the DaemonSet may not be
+	//	reporting as used yet.
+	node_result.reported_pfs = pfs
+	placement_pfs := rdma_placement.FromReported(pfs)
+	labelPFNetworks(node, placement_pfs)
+	reservations.applyTo(node.Name, placement_pfs, pod_uid)
+
+	//determine if the node's avilable resources will satisfy the pod's needs
+	policy := bandwidthPolicyForNode(node)
+	solver := placementSolver()
+	capacity, placements, placement_success, placement_err := rdma_placement.PlacePodWithConstraints(needed_resources, placement_pfs, policy, constraints, solver, false)
+
+	//if the pod's needs couldn't be met
+	if(!placement_success) {
+		//the node lacks VFs if it doesn't have a free one for
+		//	every interface. otherwise, unless the solver ran out
+		//	of time, it lacks bandwidth, unless the pod would fit
+		//	if one of its placement rules were dropped. a failed
+		//	placement leaves the PFs untouched.
+		node_result.ineligibility_cause = ineligibleInsufficientBandwidth
+		node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Node did not have enough free RDMA resources (bandwidth mode: %s).", policy)
+		if(freeVFs(placement_pfs) < len(needed_resources)) {
+			node_result.ineligibility_cause = ineligibleInsufficientVFs
+		} else if(placement_err == rdma_placement.ErrSolverTimedOut) {
+			node_result.ineligibility_cause = ineligibleSolverTimeout
+			node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Ran out of time searching for a placement of the pod's RDMA interfaces (solver budget: %s).", solver.Budget)
+		} else if(!constraints.Empty()) {
+			unmet_kind, unmet_rule, unmet_err := rdma_placement.UnmetConstraint(needed_resources, placement_pfs, policy, constraints, solver)
+			if(unmet_err == rdma_placement.ErrSolverTimedOut) {
+				node_result.ineligibility_cause = ineligibleSolverTimeout
+				node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Ran out of time working out which of the pod's placement rules the node's free RDMA resources don't meet (solver budget: %s).", solver.Budget)
+			}
+			switch unmet_kind {
+			case rdma_placement.NetworkAttachment:
+				node_result.ineligibility_cause = ineligibleNetwork
+				node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Node did not have enough free RDMA resources on the requested network (%s).", unmet_rule)
+			case rdma_placement.NumaConstraint:
+				node_result.ineligibility_cause = ineligibleNumaAffinity
+				node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Node did not have enough free RDMA resources on a single NUMA node (%s).", unmet_rule)
+			case rdma_placement.PFConstraint:
+				node_result.ineligibility_cause = ineligiblePFConstraints
+				node_result.ineligibility_reason = fmt.Sprintf("RDMA Scheduler Extension: Node's free RDMA resources are not on enough separate PFs (%s).", unmet_rule)
 			}
 		}
+		//report that back through the channel
+		node_result.enough_resources = false
+		node_result.capacity = capacity
+		output_channel <- node_result
+		return
+	//otherwise, the pod's needs could be met
+	} else {
+		//report that back through the channel
+		node_result.enough_resources = true
+		node_result.ineligibility_reason = ""
+		node_result.capacity = capacity
+		node_result.pfs = placement_pfs
+		node_result.placements = placements
+		node_result.policy = policy
+		output_channel <- node_result
+		return
 	}
-
-	//if we couldn't reach the DaemonSet on the node, return a result
-	//	stating that.
-	node_result.enough_resources = false
-	node_result.ineligibility_reason = "RDMA Scheduler Extension: Unable to collect information on available RDMA resources for node."
-	output_channel <- node_result
-	return
 }
 
 
+//freeVFs adds up the VFs left free on a node's PFs.
+func freeVFs(pfs []rdma_placement.PF) int {
+	free := 0
+	for _, pf := range pfs {
+		if(pf.CapacityVFs > pf.UsedVFs) {
+			free += int(pf.CapacityVFs - pf.UsedVFs)
+		}
+	}
+	return free
+}
+
 // HandleSchedulerFilterRequest is a callback function that processes incoming
 //	HTTP requests to the RDMA scheduler extender.
 func HandleSchedulerFilterRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
@@ -130,110 +251,138 @@ func HandleSchedulerFilterRequest(response http.ResponseWriter, request *http.Re
 	//otherwise, decoding the incoming request was successful
 	} else {
 		log.Println("Got request to schedule pod: ", sched_extender_args.Pod.ObjectMeta.Name)
+		//get the full details of the potential nodes, and the
+		//	names of any that we couldn't find details for.
+		nodes, unknown_nodes := getPotentialNodes(&sched_extender_args)
+		unknown_count := len(unknown_nodes)
+		audit := newAuditRecord("filter", sched_extender_args.Pod)
+
 		log.Println("Potential nodes to schedule on (and their addresses):")
-		for _, node := range sched_extender_args.Nodes.Items {
+		for _, node := range nodes {
 			log.Print("\t", node.Name, ": ", node.Status.Addresses)
 		}
 
 		//fill in two structures, one for nodes that can support the
 		//	pod, and another for nodes that cannot. the type of
 		//	these structures is dictated by the k8s scheduler API.
-       		canSchedule := make([]v1.Node, 0, len(sched_extender_args.Nodes.Items))
-		canNotSchedule := make(map[string]string)
-
-		//the channel over which results about whether each node can
-		//	support the pod are passed.
-		node_eligibility_channel := make(chan node_eligibility)
-
-		//read the annotations from the pod's YAML file (this is where
-		//	the information about requested RDMA resources is stored).
-		pod_annotations := sched_extender_args.Pod.ObjectMeta.Annotations
-		//if the pod does not require any RDMA interfaces
-		if(pod_annotations["rdma_interfaces_required"] == "") {
-			log.Println("Pod doesn't require any RDMA interfaces. No nodes will be filtered out.")
-			//we don't filter out any of the potential nodes
-			for _, node := range sched_extender_args.Nodes.Items {
-				canSchedule = append(canSchedule, node)
+		//	nodes we know nothing about can never support the pod.
+       		canSchedule := make([]v1.Node, 0, len(nodes))
+		canNotSchedule := unknown_nodes
+
+		//parse the JSON specifying the needed RDMA interfaces from
+		//	the pod's annotations into the relevant structure.
+		interfaces_needed, constraints, err := parseRdmaInterfacesRequired(sched_extender_args.Pod)
+		//if the RDMA interface requirements were malformatted,
+		//	reject all nodes with an error describing the
+		//	problem with each field (this error will show up
+		//	in the output for 'kubectl describe pods <pod_name>')
+		if(err != nil) {
+			log.Println("Pod's RDMA resources request was invalid: ", err)
+			for _, node := range nodes {
+				canNotSchedule[node.Name] = "RDMA Scheduler Extension: invalid RDMA resources request: " + err.Error()
 			}
-		//otherwise, if the pod does need one or more RDMA interfaces
+			audit.Verdict = auditVerdictInvalidRequest
+			audit.Error = err.Error()
 		} else {
-			//parse the JSON specifying the needed RDMA interfaces
-			//	into the relevant structure.
-			var interfaces_needed []knapsack_pod_placement.RdmaInterfaceRequest
-			err = json.Unmarshal([]byte(pod_annotations["rdma_interfaces_required"]), &interfaces_needed)
-			//if the RDMA interface requirements were malformatted,
-			//	reject all nodes with an error describing the
-			//	problem (this error will show up in the output
-			//	for 'kubectl describe pods <pod_name>')
+			//members of a gang are held back until the whole gang
+			//	can be placed, and then only fit on the node that
+			//	was planned for them, whether or not they need any
+			//	RDMA interfaces themselves
+			planned_node, err := plannedGangNode(request.Context(), sched_extender_args.Pod, nodes)
 			if(err != nil) {
-				log.Println("Pod's RDMA resources request JSON was malformatted.")
-				for _, node := range sched_extender_args.Nodes.Items {
-					canNotSchedule[node.Name] = "RDMA Scheduler Extension: 'rdma_interfaces_required' field in pod YAML file is malformatted."
+				log.Println("Pod's gang can't be placed: ", err)
+				for _, node := range nodes {
+					canNotSchedule[node.Name] = err.Error()
+				}
+				audit.Interfaces = interfaces_needed
+				audit.Verdict = auditVerdictGangWaiting
+				audit.Error = err.Error()
+				recordGangNotPlaced(sched_extender_args.Pod, err)
+			//if the pod does not require any RDMA interfaces
+			} else if(len(interfaces_needed) == 0) {
+				log.Println("Pod doesn't require any RDMA interfaces. No nodes will be filtered out, other than those not planned for its gang.")
+				//we don't filter out any of the potential nodes,
+				//	except for gang members
+				for _, node := range nodes {
+					if(planned_node != "" && node.Name != planned_node) {
+						canNotSchedule[node.Name] = gangPlanReason(planned_node)
+					} else {
+						canSchedule = append(canSchedule, node)
+					}
 				}
-			//otherwise, if the RDMA interface requirements were correctly formatted
+				audit.Verdict = auditVerdictNoRdmaNeeded
+			//otherwise, if the pod does need one or more RDMA interfaces
 			} else {
 				log.Printf("Pod's RDMA resource requirements: %+v", interfaces_needed)
 
-				//concurrently send a request to the DaemonSet
-				//	on each potential node to get information
-				//	about what RDMA resources they have available,
-				//	then determine if those reqources are enough
-				//	to satisfy the pod's request.
-				//
-				//	results from this will be passed back over the
-				//	'node_eligibility_channel'.
-				for i, node := range sched_extender_args.Nodes.Items {
-				        go queryNode(
-						i,
-						node.Status.Addresses,
-						interfaces_needed,
-						node_eligibility_channel,
-					)
-				}
+				//check whether the RDMA resources on each potential
+				//	node are enough to satisfy the pod's request.
+				results := evaluateNodes(request.Context(), nodes, sched_extender_args.Pod.ObjectMeta.UID, interfaces_needed, constraints)
+				audit.Interfaces = interfaces_needed
+				audit.Nodes = auditNodeResults(nodes, results)
 
-				//read each of the results from the 'node_eligibility_channel',
-				//	then use them to place each potential node in the cluster
-				//	into the "can schedule on" or "cannot schedule on" lists
-				//	for the pod.
-				var cur_elig node_eligibility
-				var elig [2]node_eligibility
-				//log.Println(len(node_eligibility_channel))
-				min_cap := math.MaxUint32
-				for range sched_extender_args.Nodes.Items {
-					cur_elig = <-node_eligibility_channel
-					elig[cur_elig.index] = cur_elig
-					if(cur_elig.capacity < min_cap){
-						min_cap = cur_elig.capacity
+				//a member of a gang only fits on the node planned
+				//	for it
+				for i, result := range results {
+					if(result.enough_resources && planned_node != "" && nodes[i].Name != planned_node) {
+						results[i].enough_resources = false
+						results[i].ineligibility_reason = gangPlanReason(planned_node)
+						results[i].ineligibility_cause = ineligibleGangPlan
+						audit.Nodes[i] = auditNodeResult(nodes[i].Name, results[i])
 					}
 				}
-				i:=0
+				recordPlacementResults(results)
+
+				//use the results to place each potential node in the
+				//	cluster into the "can schedule on" or "cannot
+				//	schedule on" lists for the pod.
 				log.Println("Results from querying each node:")
-				for range sched_extender_args.Nodes.Items {
-					//cur_elig = <-node_eligibility_channel
-					//log.Println(cur_elig.capacity)
-					if elig[i].enough_resources && elig[i].capacity == min_cap {
-						log.Println("\t", sched_extender_args.Nodes.Items[elig[i].index].Name, "Capacity", elig[i].capacity)
-						log.Println("\t", sched_extender_args.Nodes.Items[elig[i].index].Name, ": Eligible")
-						canSchedule = append(canSchedule, sched_extender_args.Nodes.Items[elig[i].index])
+				for i, result := range results {
+					//preferences between eligible nodes are expressed
+					//	through the prioritize verb, so every node
+					//	that can fit the pod is kept here.
+					if(result.enough_resources) {
+						log.Println("\t", nodes[i].Name, "Capacity", result.capacity)
+						log.Println("\t", nodes[i].Name, ": Eligible")
+						canSchedule = append(canSchedule, nodes[i])
 					} else {
-						log.Println("\t", sched_extender_args.Nodes.Items[elig[i].index].Name, "Capacity", elig[i].capacity)
-						log.Println("\t", sched_extender_args.Nodes.Items[elig[i].index].Name, ": Not Eligible")
-						canNotSchedule[sched_extender_args.Nodes.Items[elig[i].index].Name] = elig[i].ineligibility_reason
+						log.Println("\t", nodes[i].Name, ": Not Eligible (", result.ineligibility_reason, ")")
+						canNotSchedule[nodes[i].Name] = result.ineligibility_reason
 					}
-					log.Println("")
-					i++
+				}
+				if(len(canSchedule) > 0) {
+					audit.Verdict = auditVerdictSchedulable
+				} else {
+					audit.Verdict = auditVerdictUnschedulable
+					recordNoNodeFits(sched_extender_args.Pod, results, unknown_count)
 				}
 			}
 		}
 
+		audit_log.record(audit)
+
 		//build a results structure from the lists of nodes that can
 		//	and cannot meet the RDMA needs of the pod to be scheduled.
-		extender_filter_results = &schedulerapi.ExtenderFilterResult{
-			Nodes: &v1.NodeList{
-				Items: canSchedule,
-			},
-			FailedNodes: canNotSchedule,
-			Error:       "",
+		//	if the scheduler only sent us node names, it expects
+		//	only node names back.
+		if(sched_extender_args.NodeNames != nil) {
+			canScheduleNames := make([]string, 0, len(canSchedule))
+			for _, node := range canSchedule {
+				canScheduleNames = append(canScheduleNames, node.Name)
+			}
+			extender_filter_results = &schedulerapi.ExtenderFilterResult{
+				NodeNames: &canScheduleNames,
+				FailedNodes: canNotSchedule,
+				Error:       "",
+			}
+		} else {
+			extender_filter_results = &schedulerapi.ExtenderFilterResult{
+				Nodes: &v1.NodeList{
+					Items: canSchedule,
+				},
+				FailedNodes: canNotSchedule,
+				Error:       "",
+			}
 		}
 	}
 
@@ -257,19 +406,161 @@ func getEnvVar(var_name string, default_value string) string {
 	return var_value
 }
 
+// getEnvVarInt reads the integer value of an environment variable, returning
+//     a specified default value if the variable is empty. the program exits
+//     if the variable is set to something that isn't an integer.
+func getEnvVarInt(var_name string, default_value int) int {
+	var_value, err := strconv.Atoi(getEnvVar(var_name, strconv.Itoa(default_value)))
+	if(err != nil) {
+		log.Fatal("Invalid value for ", var_name, ": ", err)
+	}
+
+	return var_value
+}
+
 
 func main() {
+	//load the extender's settings from environment variables, overridden
+	//	by the config file if one is specified
+	env_config := configFromEnv()
+	config := env_config
+	config_file := getEnvVar("CONFIG_FILE", "")
+	if(config_file != "") {
+		var err error
+		config, err = loadConfigFile(config_file, env_config)
+		if(err != nil) {
+			log.Fatal("Unable to load config file: ", err)
+		}
+		log.Println("RDMA scheduler extender loaded config file: ", config_file)
+	}
+	errs := config.validate()
+	if(len(errs) > 0) {
+		log.Fatal("Invalid configuration: ", errs.ToAggregate())
+	}
+	err := activateConfig(config)
+	if(err != nil) {
+		log.Fatal("Unable to set up queries to RDMA hardware DaemonSets: ", err)
+	}
+	log.Println("RDMA scheduler extender handing out bandwidth using policy: ", clusterBandwidthPolicy())
+	log.Println("RDMA scheduler extender scoring nodes using policy: ", config.Placement.ScoringPolicy)
+
 	//we will create an HTTP server that listens for queries to a specific URL
 	router := httprouter.New()
-	router.POST(RdmaSchedulerExtenderHttpListenPath, HandleSchedulerFilterRequest)
+	router.POST(config.Server.FilterPath, requireClientCert(instrumentVerb("filter", HandleSchedulerFilterRequest)))
+	router.POST(config.Server.PrioritizePath, requireClientCert(instrumentVerb("prioritize", HandleSchedulerPrioritizeRequest)))
+	router.POST(config.Server.BindPath, requireClientCert(instrumentVerb("bind", HandleSchedulerBindRequest)))
+	router.POST(config.Server.PreemptPath, requireClientCert(instrumentVerb("preempt", HandleSchedulerPreemptRequest)))
+	router.Handler("GET", RdmaSchedulerExtenderMetricsPath, promhttp.Handler())
+	router.GET(RdmaSchedulerExtenderHealthzPath, HandleHealthzRequest)
+	router.GET(RdmaSchedulerExtenderReadyzPath, HandleReadyzRequest)
+	router.GET(RdmaSchedulerExtenderVersionPath, HandleVersionRequest)
+	router.GET(RdmaSchedulerExtenderConfigPath, requireClientCert(HandleConfigRequest))
 
-	//get the port to listen on from an environment variable, or use default
-	port := getEnvVar("PORT", RdmaSchedulerExtenderDefaultPort)
-
-	//listent on specified port
-	log.Println("RDMA scheduler extender listening on port: ", port)
-	err := http.ListenAndServe(":" + port, router)
+	//connect to the k8s API server, which is needed to bind pods
+	kube_client, err = getKubeClient()
 	if(err != nil) {
+		log.Println("Unable to connect to the k8s API server, binding pods will fail: ", err)
+	}
+
+	//cancelled when shutting down, to stop work done in the background
+	background_ctx, stop_background := context.WithCancel(context.Background())
+
+	//pick up changes to the config file without restarting
+	if(config_file != "") {
+		go watchConfigFile(background_ctx, config_file, env_config, RdmaSchedulerConfigFileCheckInterval)
+	}
+
+	//load the fabric topology used to keep the pods of a job close
+	//	together, and pick up changes to it (or to where it is read
+	//	from) without restarting
+	topology := &topology_loader{}
+	topology.refresh()
+	go watchTopology(background_ctx, topology, RdmaSchedulerTopologyCheckInterval)
+
+	//watch the cluster so that reservations are released as soon as
+	//	their pods are deleted, and so that nodes and pods can be
+	//	looked up when the scheduler is 'nodeCacheCapable'
+	if(kube_client != nil) {
+		event_recorder = newEventRecorder(kube_client)
+		informer_factory := informers.NewSharedInformerFactory(kube_client, 0)
+		watchPodDeletions(informer_factory, reservations)
+		node_lister = informer_factory.Core().V1().Nodes().Lister()
+		pod_indexer, err = indexPodsByUID(informer_factory)
+		if(err != nil) {
+			log.Fatal("Unable to index pods by UID: ", err)
+		}
+		err = indexPodsByGang(informer_factory)
+		if(err != nil) {
+			log.Fatal("Unable to index pods by gang: ", err)
+		}
+		informer_factory.Start(wait.NeverStop)
+		informer_factory.WaitForCacheSync(wait.NeverStop)
+
+		//keep a snapshot of the RDMA resources on every node in
+		//	the background, rather than querying each node's
+		//	DaemonSet while a pod is waiting to be scheduled
+		refreshInventory(background_ctx, inventory, node_lister)
+		inventory.startPolling()
+		go pollInventory(background_ctx, inventory, node_lister)
+	}
+
+	//the admission webhook needs a TLS certificate the k8s API server
+	//	trusts, so it is only started when one is configured
+	var webhook_server *http.Server
+	if(config.Server.WebhookTLSCertFile != "" && config.Server.WebhookTLSKeyFile != "") {
+		webhook_server = newWebhookServer(config.Server.WebhookPort, config.Server.WebhookTLSCertFile, config.Server.WebhookTLSKeyFile)
+		go serveWebhook(webhook_server)
+	}
+
+	//write an audit record of each scheduling decision, if a file for
+	//	them is configured
+	if(config.Server.AuditLogFile != "") {
+		audit_file, err := openRotatingFile(config.Server.AuditLogFile, int64(config.Server.AuditLogMaxSizeMB) * 1024 * 1024, config.Server.AuditLogMaxBackups)
+		if(err != nil) {
+			log.Fatal("Unable to open audit log file: ", err)
+		}
+		audit_log = &audit_logger{file: audit_file}
+		log.Println("RDMA scheduler extender writing audit records to: ", config.Server.AuditLogFile)
+	}
+
+	port := config.Server.Port
+	server := &http.Server{
+		Addr: ":" + port,
+		Handler: router,
+	}
+
+	//drain in-flight requests when asked to terminate
+	drain_timeout := time.Duration(config.Server.DrainTimeoutSeconds) * time.Second
+	shutdown_grace := time.Duration(config.Server.ShutdownGraceSeconds) * time.Second
+	servers := []*http.Server{server}
+	if(webhook_server != nil) {
+		servers = append(servers, webhook_server)
+	}
+	drained := make(chan struct{})
+	go shutdownOnSignal(servers, shutdown_grace, drain_timeout, stop_background, drained)
+
+	//serve HTTPS if a certificate is configured, optionally checking the
+	//	scheduler's client certificate
+	if(config.Server.TLSCertFile != "" && config.Server.TLSKeyFile != "") {
+		server.TLSConfig, err = newServerTLSConfig(config.Server.TLSCertFile, config.Server.TLSKeyFile, config.Server.TLSClientCAFile)
+		if(err != nil) {
+			log.Fatal("Unable to set up TLS: ", err)
+		}
+		client_cert_required = (config.Server.TLSClientCAFile != "")
+
+		log.Println("RDMA scheduler extender version ", build_version, " (", build_commit, ") listening for HTTPS on port: ", port)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		//listent on specified port
+		log.Println("RDMA scheduler extender version ", build_version, " (", build_commit, ") listening on port: ", port)
+		err = server.ListenAndServe()
+	}
+	if(err != http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+
+	//the server stops listening as soon as shutdown starts, so wait for
+	//	in-flight requests to finish before exiting
+	<-drained
+	log.Println("RDMA scheduler extender shut down.")
 }
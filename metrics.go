@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+)
+
+//reasons a node can't take a pod, as counted by the ineligible nodes metric.
+const (
+	ineligibleUnreachable string = "unreachable"
+	ineligibleStale string = "stale"
+	ineligibleCircuitOpen string = "circuit_open"
+	ineligibleInsufficientVFs string = "insufficient_vfs"
+	ineligibleInsufficientBandwidth string = "insufficient_bandwidth"
+	ineligibleNetwork string = "network"
+	ineligibleNumaAffinity string = "numa_affinity"
+	ineligiblePFConstraints string = "pf_constraints"
+	ineligibleTimeout string = "timeout"
+	ineligibleSolverTimeout string = "solver_timeout"
+	ineligibleGangPlan string = "gang_plan"
+)
+
+var (
+	request_duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "rdma_scheduler_request_duration_seconds",
+		Help: "Time taken to answer requests, by scheduler extender verb.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"verb"})
+
+	node_query_duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "rdma_scheduler_node_query_duration_seconds",
+		Help: "Time taken to query the RDMA hardware DaemonSet on a node.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"node"})
+
+	node_query_errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdma_scheduler_node_query_errors_total",
+		Help: "Failed queries to the RDMA hardware DaemonSet on a node, by cause.",
+	}, []string{"node", "cause"})
+
+	placement_attempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdma_scheduler_placements_total",
+		Help: "Attempts to place a pod's RDMA interfaces on a node, by result.",
+	}, []string{"result"})
+
+	ineligible_nodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdma_scheduler_ineligible_nodes_total",
+		Help: "Nodes found unable to take a pod, by reason.",
+	}, []string{"reason"})
+
+	free_vfs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rdma_scheduler_free_vfs",
+		Help: "Free VFs on a PF, as of the node's last snapshot.",
+	}, []string{"node", "pf"})
+
+	free_tx_rate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rdma_scheduler_free_tx_rate",
+		Help: "Free bandwidth (by min tx rate) on a PF, as of the node's last snapshot.",
+	}, []string{"node", "pf"})
+)
+
+func init() {
+	prometheus.MustRegister(request_duration, node_query_duration, node_query_errors, placement_attempts, ineligible_nodes, free_vfs, free_tx_rate)
+}
+
+//instrumentVerb wraps the handler of a scheduler extender verb so that the
+//	time taken to answer each request is recorded.
+func instrumentVerb(verb string, handle httprouter.Handle) httprouter.Handle {
+	return func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		start := time.Now()
+		handle(response, request, params)
+		request_duration.WithLabelValues(verb).Observe(time.Since(start).Seconds())
+	}
+}
+
+//queryErrorCause sorts an error from querying a node's DaemonSet into one
+//	of the causes counted by the node query errors metric. errors are
+//	looked for anywhere in the chain of wrapped errors, since the HTTP
+//	client and TLS wrap the errors that caused a query to fail.
+func queryErrorCause(err error) string {
+	if(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return "cancelled"
+	}
+	var net_err net.Error
+	if(errors.As(err, &net_err) && net_err.Timeout()) {
+		return "timeout"
+	}
+	var syntax_err *json.SyntaxError
+	var type_err *json.UnmarshalTypeError
+	if(errors.As(err, &syntax_err) || errors.As(err, &type_err)) {
+		return "decode"
+	}
+	var authority_err x509.UnknownAuthorityError
+	var hostname_err x509.HostnameError
+	var invalid_err x509.CertificateInvalidError
+	if(errors.As(err, &authority_err) || errors.As(err, &hostname_err) || errors.As(err, &invalid_err)) {
+		return "tls"
+	}
+	var status_err *node_query_status_error
+	if(errors.As(err, &status_err) && (status_err.status_code == http.StatusUnauthorized || status_err.status_code == http.StatusForbidden)) {
+		return "unauthorized"
+	}
+	if(errors.Is(err, syscall.ECONNREFUSED)) {
+		return "connection_refused"
+	}
+	return "other"
+}
+
+//recordNodeQuery records how long a query to a node's DaemonSet took, and
+//	why it failed if it did.
+func recordNodeQuery(node_name string, start time.Time, err error) {
+	node_query_duration.WithLabelValues(node_name).Observe(time.Since(start).Seconds())
+	if(err != nil) {
+		node_query_errors.WithLabelValues(node_name, queryErrorCause(err)).Inc()
+	}
+}
+
+//recordPlacementResults counts what was found when a pod's interfaces were
+//	placed on each of the potential nodes of a filter request. it is only
+//	called by the filter verb, so that each scheduling attempt is counted
+//	once however many verbs look at the same nodes.
+func recordPlacementResults(results []node_eligibility) {
+	for _, result := range results {
+		if(result.enough_resources) {
+			placement_attempts.WithLabelValues("success").Inc()
+			continue
+		}
+		ineligible_nodes.WithLabelValues(result.ineligibility_cause).Inc()
+		//nodes that weren't checked had no placement attempted, and
+		//	nodes only turned down for not being the one planned for
+		//	the pod's gang had one that succeeded
+		switch result.ineligibility_cause {
+		case ineligibleUnreachable, ineligibleStale, ineligibleCircuitOpen, ineligibleTimeout:
+		case ineligibleGangPlan:
+			placement_attempts.WithLabelValues("success").Inc()
+		default:
+			placement_attempts.WithLabelValues("failure").Inc()
+		}
+	}
+}
+
+//recordSnapshot sets the free resources gauges of a node's PFs.
+func recordSnapshot(node_name string, pfs []rdma_placement.ReportedPF) {
+	for _, pf := range pfs {
+		free_vfs.WithLabelValues(node_name, pf.Name).Set(float64(pf.CapacityVFs) - float64(pf.UsedVFs))
+		free_tx_rate.WithLabelValues(node_name, pf.Name).Set(float64(pf.CapacityTxRate) - float64(pf.UsedTxRate))
+	}
+}
+
+//forgetSnapshot removes the free resources gauges of a node's PFs.
+func forgetSnapshot(node_name string, pfs []rdma_placement.ReportedPF) {
+	for _, pf := range pfs {
+		free_vfs.DeleteLabelValues(node_name, pf.Name)
+		free_tx_rate.DeleteLabelValues(node_name, pf.Name)
+	}
+}
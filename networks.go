@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+//validateNetworkName checks a network name, which is either a DNS-1123
+//	label or, like a Multus network reference, a namespace and a DNS-1123
+//	label separated by a '/'. it returns what is wrong with each part.
+func validateNetworkName(network string) []string {
+	parts := strings.SplitN(network, "/", 2)
+	if(len(parts) == 1) {
+		return validation.IsDNS1123Label(network)
+	}
+
+	var errs []string
+	for _, msg := range validation.IsDNS1123Label(parts[0]) {
+		errs = append(errs, "namespace: " + msg)
+	}
+	for _, msg := range validation.IsDNS1123Label(parts[1]) {
+		errs = append(errs, "name: " + msg)
+	}
+	return errs
+}
+
+//labelPFNetworks sets the network each of a node's PFs is cabled to from
+//	the node's 'rdma_network.<pf name>' labels, which take precedence over
+//	what the PF's DaemonSet reported. label values can't contain a '/', so
+//	a namespaced network is written as '<namespace>.<name>'. invalid labels
+//	are ignored.
+func labelPFNetworks(node *v1.Node, pfs []rdma_placement.PF) {
+	if(node == nil) {
+		return
+	}
+
+	for label, network := range node.ObjectMeta.Labels {
+		if(!strings.HasPrefix(label, RdmaPFNetworkLabelPrefix)) {
+			continue
+		}
+		pf_name := strings.TrimPrefix(label, RdmaPFNetworkLabelPrefix)
+		//DNS-1123 labels have no dots, so the first one can only be
+		//	the end of the namespace
+		network = strings.Replace(network, ".", "/", 1)
+		errs := validateNetworkName(network)
+		if(len(errs) > 0) {
+			log.Println("Ignoring invalid network label for PF ", pf_name, " on node ", node.Name, ": ", strings.Join(errs, "; "))
+			continue
+		}
+		for i := range pfs {
+			if(pfs[i].Name == pf_name) {
+				pfs[i].Network = network
+			}
+		}
+	}
+}
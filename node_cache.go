@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+//local cache of the cluster's nodes, kept up to date by an informer. this
+//	is nil if no connection to the k8s API server could be configured.
+var node_lister corelisters.NodeLister
+
+//getPotentialNodes returns the nodes that a scheduler extender request asks
+//	about. when the scheduler is configured as 'nodeCacheCapable' it only
+//	sends the names of the nodes, which are then looked up in the local
+//	node cache. nodes that can't be found there are returned in a
+//	separate map, along with the reason they couldn't be considered.
+func getPotentialNodes(sched_extender_args *schedulerapi.ExtenderArgs) ([]v1.Node, map[string]string) {
+	unknown_nodes := make(map[string]string)
+
+	//the scheduler sent full node objects
+	if(sched_extender_args.NodeNames == nil) {
+		if(sched_extender_args.Nodes == nil) {
+			return []v1.Node{}, unknown_nodes
+		}
+		return sched_extender_args.Nodes.Items, unknown_nodes
+	}
+
+	//the scheduler only sent node names
+	nodes := make([]v1.Node, 0, len(*sched_extender_args.NodeNames))
+	for _, node_name := range *sched_extender_args.NodeNames {
+		if(node_lister == nil) {
+			unknown_nodes[node_name] = "RDMA Scheduler Extension: No node cache is available to look up node."
+			continue
+		}
+		node, err := node_lister.Get(node_name)
+		if(err != nil) {
+			unknown_nodes[node_name] = "RDMA Scheduler Extension: Node was not found in node cache."
+			continue
+		}
+		nodes = append(nodes, *node)
+	}
+
+	return nodes, unknown_nodes
+}
+
+//getNode looks up a single node by name, in the local node cache if we have
+//	one, or otherwise by asking the k8s API server.
+func getNode(node_name string) (*v1.Node, error) {
+	if(node_lister != nil) {
+		return node_lister.Get(node_name)
+	}
+	if(kube_client == nil) {
+		return nil, errors.New("RDMA Scheduler Extension: no connection to the k8s API server is configured.")
+	}
+
+	return kube_client.CoreV1().Nodes().Get(node_name, metav1.GetOptions{})
+}
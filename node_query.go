@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+)
+
+//the largest response accepted from a DaemonSet, to keep a misbehaving one
+//	from exhausting the extender's memory.
+const nodeQueryMaxResponseSize int64 = 1024 * 1024
+
+//error returned when a DaemonSet answers with anything other than success.
+type node_query_status_error struct {
+	status_code int
+	status string
+}
+
+func (err *node_query_status_error) Error() string {
+	return "RDMA hardware DaemonSet answered with status " + err.status
+}
+
+//node_query_client queries the RDMA hardware DaemonSet on a node for the PFs
+//	it has. it does the same as rdma_hardware_info.QueryNode, but can
+//	use HTTPS, check the DaemonSet's certificate, and identify itself
+//	with a bearer token or a client certificate.
+type node_query_client struct {
+	scheme string
+	port string
+	client *http.Client
+	//file the bearer token is read from for each query, so that
+	//	rotated tokens are picked up. no token is sent if empty.
+	token_file string
+	//how many more times every address of a node is tried after the
+	//	first round of queries fails, and the base of the backoff
+	//	between rounds
+	retries int
+	retry_backoff time.Duration
+}
+
+//the client used for all queries to DaemonSets. always holds a
+//	*node_query_client, which is replaced when the settings for node
+//	queries change. by default it behaves the same as
+//	rdma_hardware_info.QueryNode.
+var active_node_query atomic.Value
+
+func init() {
+	active_node_query.Store(&node_query_client{
+		scheme: "http",
+		port: rdma_hardware_info.DefaultPort,
+		client: &http.Client{Timeout: time.Duration(RdmaSchedulerDefaultNodeQueryTimeout) * time.Millisecond},
+		retries: RdmaSchedulerDefaultNodeQueryRetries,
+		retry_backoff: RdmaSchedulerDefaultNodeQueryRetryBackoff,
+	})
+}
+
+//currentNodeQuery returns the client to use for queries to DaemonSets.
+func currentNodeQuery() *node_query_client {
+	return active_node_query.Load().(*node_query_client)
+}
+
+//newNodeQueryClient sets up a client for querying DaemonSets. the scheme is
+//	either 'http' or 'https', and the TLS settings are only used with
+//	https. a CA file limits which certificates DaemonSets may present, and
+//	a server name is checked against their certificates instead of the
+//	address they are reached at. a bearer token or client certificate
+//	can only be used with https, so that they aren't sent in cleartext
+//	or silently left out.
+func newNodeQueryClient(config node_query_config) (*node_query_client, error) {
+	if(config.Scheme != "http" && config.Scheme != "https") {
+		return nil, fmt.Errorf("unknown node query scheme '%s', expected http or https", config.Scheme)
+	}
+	if(config.Scheme == "http" && config.TokenFile != "") {
+		return nil, errors.New("a node query token file can only be used with the https scheme")
+	}
+	if(config.Scheme == "http" && (config.ClientCertFile != "" || config.ClientKeyFile != "")) {
+		return nil, errors.New("a node query client certificate can only be used with the https scheme")
+	}
+
+	transport := &http.Transport{}
+	if(config.Scheme == "https") {
+		tls_config := &tls.Config{
+			ServerName: config.ServerName,
+			MinVersion: tls.VersionTLS12,
+		}
+		if(config.CAFile != "") {
+			ca_pem, err := ioutil.ReadFile(config.CAFile)
+			if(err != nil) {
+				return nil, err
+			}
+			cas := x509.NewCertPool()
+			if(!cas.AppendCertsFromPEM(ca_pem)) {
+				return nil, errors.New("no certificates found in node query CA file " + config.CAFile)
+			}
+			tls_config.RootCAs = cas
+		}
+		if(config.ClientCertFile != "" || config.ClientKeyFile != "") {
+			reloader, err := newCertificateReloader(config.ClientCertFile, config.ClientKeyFile)
+			if(err != nil) {
+				return nil, err
+			}
+			tls_config.GetClientCertificate = reloader.GetClientCertificate
+		}
+		transport.TLSClientConfig = tls_config
+	}
+
+	return &node_query_client{
+		scheme: config.Scheme,
+		port: config.Port,
+		client: &http.Client{
+			Transport: transport,
+			Timeout: time.Duration(config.TimeoutMs) * time.Millisecond,
+		},
+		token_file: config.TokenFile,
+		retries: config.Retries,
+		retry_backoff: time.Duration(config.RetryBackoffMs) * time.Millisecond,
+	}, nil
+}
+
+//retryBackoff returns how long to wait before a retry. the backoff doubles
+//	with each attempt, and is jittered so that queries to a struggling
+//	DaemonSet from concurrent requests don't all land at once.
+func (query *node_query_client) retryBackoff(attempt int) time.Duration {
+	backoff := query.retry_backoff << uint(attempt - 1)
+	return backoff / 2 + time.Duration(rand.Int63n(int64(backoff / 2) + 1))
+}
+
+//queryNode asks the DaemonSet at an address for the PFs on its node. the
+//	query is abandoned if the context is cancelled.
+func (query *node_query_client) queryNode(ctx context.Context, node_address string) ([]rdma_placement.ReportedPF, error) {
+	url := fmt.Sprintf("%s://%s/%s", query.scheme, net.JoinHostPort(node_address, query.port), rdma_hardware_info.RdmaInfoUrl)
+	request, err := http.NewRequest("GET", url, nil)
+	if(err != nil) {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	if(query.token_file != "") {
+		token, err := ioutil.ReadFile(query.token_file)
+		if(err != nil) {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer " + strings.TrimSpace(string(token)))
+	}
+
+	response, err := query.client.Do(request)
+	if(err != nil) {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if(response.StatusCode != http.StatusOK) {
+		return nil, &node_query_status_error{status_code: response.StatusCode, status: response.Status}
+	}
+
+	//deserialize response into a list of PF objects
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, nodeQueryMaxResponseSize))
+	if(err != nil) {
+		return nil, err
+	}
+	var pfs []rdma_placement.ReportedPF
+	err = json.Unmarshal(data, &pfs)
+	if(err != nil) {
+		return nil, err
+	}
+
+	return pfs, nil
+}
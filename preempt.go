@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+//name of the pod informer index that maps pod UIDs to pods.
+const podUIDIndex string = "uid"
+
+//the most sets of victims holding RDMA interfaces that are tried when
+//	looking for the smallest set that needs to be evicted.
+const maxRdmaVictimSets int = 1000
+
+//local cache of the cluster's pods, indexed by UID. this is used to look up
+//	the victims of a preemption when the scheduler only sends their UIDs.
+//	it is nil if no connection to the k8s API server could be configured.
+var pod_indexer cache.Indexer
+
+//indexPodsByUID adds an index on pod UIDs to the pod informer, and returns
+//	the informer's indexer.
+func indexPodsByUID(informer_factory informers.SharedInformerFactory) (cache.Indexer, error) {
+	pod_informer := informer_factory.Core().V1().Pods().Informer()
+	err := pod_informer.AddIndexers(cache.Indexers{
+		podUIDIndex: func(obj interface{}) ([]string, error) {
+			pod, is_pod := obj.(*v1.Pod)
+			if(!is_pod) {
+				return []string{}, nil
+			}
+			return []string{string(pod.ObjectMeta.UID)}, nil
+		},
+	})
+	if(err != nil) {
+		return nil, err
+	}
+
+	return pod_informer.GetIndexer(), nil
+}
+
+//getPodsByUID looks up the victims of a preemption in the local pod cache.
+func getPodsByUID(meta_pods []*schedulerapi.MetaPod) ([]*v1.Pod, error) {
+	if(pod_indexer == nil) {
+		return nil, errors.New("no pod cache is available to look up victims")
+	}
+
+	pods := make([]*v1.Pod, 0, len(meta_pods))
+	for _, meta_pod := range meta_pods {
+		objs, err := pod_indexer.ByIndex(podUIDIndex, meta_pod.UID)
+		if(err != nil) {
+			return nil, err
+		}
+		if(len(objs) == 0) {
+			return nil, fmt.Errorf("victim pod with UID %s was not found in pod cache", meta_pod.UID)
+		}
+		pods = append(pods, objs[0].(*v1.Pod))
+	}
+
+	return pods, nil
+}
+
+//getPodPlacement reads where the extender placed a pod's RDMA interfaces
+//	from the pod's annotations. pods that were not placed by the extender
+//	(or that hold no RDMA interfaces) have an empty placement.
+func getPodPlacement(pod *v1.Pod) []rdma_interface_placement {
+	var placement []rdma_interface_placement
+	annotation := pod.ObjectMeta.Annotations[currentConfig().Annotations.InterfacePlacement]
+	if(annotation == "") {
+		return placement
+	}
+	err := json.Unmarshal([]byte(annotation), &placement)
+	if(err != nil) {
+		log.Println("Ignoring malformatted RDMA interface placement of pod ", pod.ObjectMeta.Namespace, "/", pod.ObjectMeta.Name, ": ", err)
+		return []rdma_interface_placement{}
+	}
+
+	return placement
+}
+
+//podFitsWithoutVictims determines whether a pod's RDMA interfaces could be
+//	placed on a node's PFs if the specified victims were evicted.
+func podFitsWithoutVictims(interfaces_needed []knapsack_pod_placement.RdmaInterfaceRequest,
+	constraints rdma_placement.Constraints,
+	pfs []rdma_placement.PF,
+	policy rdma_placement.BandwidthPolicy,
+	victims []*v1.Pod) bool {
+
+	//work on a copy, since both freeing resources and placing the pod
+	//	modify the PFs
+	pfs = append([]rdma_placement.PF(nil), pfs...)
+
+	//give the resources held by each victim back to the PFs they are on
+	for _, victim := range victims {
+		for _, iface := range getPodPlacement(victim) {
+			for i := range pfs {
+				if(pfs[i].Name == iface.PF) {
+					pfs[i].Give(iface.MinTxRate, iface.MaxTxRate)
+				}
+			}
+		}
+	}
+
+	//only whether the pod fits matters here, not how well
+	_, _, placement_success, _ := rdma_placement.PlacePodWithConstraints(interfaces_needed, pfs, policy, constraints, placementSolver().FirstFit(), false)
+	return placement_success
+}
+
+//podPriority returns the priority of a pod, treating pods without one as
+//	having the default priority of 0.
+func podPriority(pod *v1.Pod) int32 {
+	if(pod.Spec.Priority == nil) {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+//smallestVictimSet looks for a set of fewer than 'limit' victims whose
+//	eviction is enough for the pod to fit. sets are tried in order of
+//	size and, within a size, in the order of 'victims', so the victims
+//	listed first are preferred. it gives up after trying 'max_sets' sets,
+//	and returns false if it didn't find one.
+func smallestVictimSet(victims []*v1.Pod, limit int, max_sets int, fits func([]*v1.Pod) bool) ([]*v1.Pod, bool) {
+	var tried int = 0
+	for size := 1; size < limit && size <= len(victims); size++ {
+		//the indices of the victims in the set being tried, in
+		//	increasing order
+		indices := make([]int, size)
+		for i := range indices {
+			indices[i] = i
+		}
+		for {
+			if(tried >= max_sets) {
+				return nil, false
+			}
+			tried++
+
+			set := make([]*v1.Pod, size)
+			for i, index := range indices {
+				set[i] = victims[index]
+			}
+			if(fits(set)) {
+				return set, true
+			}
+
+			//move on to the next set of the same size, or the next
+			//	size once they have all been tried
+			i := size - 1
+			for i >= 0 && indices[i] == len(victims) - size + i {
+				i--
+			}
+			if(i < 0) {
+				break
+			}
+			indices[i]++
+			for j := i + 1; j < size; j++ {
+				indices[j] = indices[j - 1] + 1
+			}
+		}
+	}
+
+	return nil, false
+}
+
+//selectRdmaVictims trims the victims proposed for a node down to those that
+//	need to be evicted to free enough RDMA resources for the pod. victims
+//	that hold no RDMA interfaces were proposed to free other resources,
+//	such as CPU or memory, so they are always kept. victims holding RDMA
+//	interfaces are first reprieved one at a time, most important first,
+//	as long as the pod's interfaces still fit without evicting them. that
+//	leaves a set none of which can be spared, but not always the smallest
+//	one, so smaller sets are then searched for, least important victims
+//	first, trying at most 'maxRdmaVictimSets' of them. it returns false
+//	if evicting all of the proposed victims still wouldn't free enough
+//	RDMA resources.
+func selectRdmaVictims(ctx context.Context,
+	pod *v1.Pod,
+	interfaces_needed []knapsack_pod_placement.RdmaInterfaceRequest,
+	constraints rdma_placement.Constraints,
+	node_name string,
+	victims []*v1.Pod) ([]*v1.Pod, bool, error) {
+
+	node, err := getNode(node_name)
+	if(err != nil) {
+		return nil, false, err
+	}
+	reported_pfs, err := getNodePFs(ctx, node_name, node.Status.Addresses)
+	if(err != nil) {
+		return nil, false, err
+	}
+	pfs := rdma_placement.FromReported(reported_pfs)
+	labelPFNetworks(node, pfs)
+	reservations.applyTo(node_name, pfs, pod.ObjectMeta.UID)
+	policy := bandwidthPolicyForNode(node)
+
+	if(!podFitsWithoutVictims(interfaces_needed, constraints, pfs, policy, victims)) {
+		return nil, false, nil
+	}
+
+	//only victims holding RDMA interfaces can be reprieved here
+	var kept []*v1.Pod
+	var rdma_victims []*v1.Pod
+	for _, victim := range victims {
+		if(len(getPodPlacement(victim)) > 0) {
+			rdma_victims = append(rdma_victims, victim)
+		} else {
+			kept = append(kept, victim)
+		}
+	}
+
+	//try to reprieve the most important victims first
+	proposed := rdma_victims
+	sort.SliceStable(rdma_victims, func(i, j int) bool {
+		return podPriority(rdma_victims[i]) > podPriority(rdma_victims[j])
+	})
+	for i := 0; i < len(rdma_victims); {
+		candidate := append(append([]*v1.Pod(nil), rdma_victims[:i]...), rdma_victims[i+1:]...)
+		if(podFitsWithoutVictims(interfaces_needed, constraints, pfs, policy, candidate)) {
+			rdma_victims = candidate
+		} else {
+			i++
+		}
+	}
+
+	//look for a smaller set among all of the proposed victims, trying
+	//	the least important ones first
+	least_important_first := append([]*v1.Pod(nil), proposed...)
+	sort.SliceStable(least_important_first, func(i, j int) bool {
+		return podPriority(least_important_first[i]) < podPriority(least_important_first[j])
+	})
+	smaller, found := smallestVictimSet(least_important_first, len(rdma_victims), maxRdmaVictimSets, func(set []*v1.Pod) bool {
+		return podFitsWithoutVictims(interfaces_needed, constraints, pfs, policy, set)
+	})
+	if(found) {
+		rdma_victims = smaller
+	}
+
+	return append(kept, rdma_victims...), true, nil
+}
+
+//preemptForPod works out which of the proposed victims on each node actually
+//	need to be evicted for the pod's RDMA interfaces to fit. nodes where
+//	evicting every proposed victim still isn't enough are left out of the
+//	result.
+func preemptForPod(ctx context.Context, preemption_args *schedulerapi.ExtenderPreemptionArgs) (*schedulerapi.ExtenderPreemptionResult, error) {
+	result := &schedulerapi.ExtenderPreemptionResult{
+		NodeNameToMetaVictims: make(map[string]*schedulerapi.MetaVictims),
+	}
+
+	//collect the full pod objects of the victims on each node
+	node_victims := make(map[string]*schedulerapi.Victims)
+	if(preemption_args.NodeNameToVictims != nil) {
+		node_victims = preemption_args.NodeNameToVictims
+	} else {
+		for node_name, meta_victims := range preemption_args.NodeNameToMetaVictims {
+			pods, err := getPodsByUID(meta_victims.Pods)
+			if(err != nil) {
+				return nil, err
+			}
+			node_victims[node_name] = &schedulerapi.Victims{
+				Pods: pods,
+				NumPDBViolations: meta_victims.NumPDBViolations,
+			}
+		}
+	}
+
+	interfaces_needed, constraints, err := parseRdmaInterfacesRequired(preemption_args.Pod)
+	if(err != nil) {
+		return nil, fmt.Errorf("invalid RDMA resources request: %v", err)
+	}
+
+	for node_name, victims := range node_victims {
+		selected := victims.Pods
+		//pods that don't need RDMA interfaces aren't held back by RDMA
+		//	resources, so the proposed victims are left alone
+		if(len(interfaces_needed) > 0) {
+			var fits bool
+			selected, fits, err = selectRdmaVictims(ctx, preemption_args.Pod, interfaces_needed, constraints, node_name, victims.Pods)
+			if(err != nil) {
+				log.Println("\t", node_name, ": unable to check RDMA resources: ", err)
+				continue
+			}
+			if(!fits) {
+				log.Println("\t", node_name, ": preemption would not free enough RDMA resources")
+				continue
+			}
+		}
+
+		log.Println("\t", node_name, ": evicting ", len(selected), " of ", len(victims.Pods), " proposed victims")
+		meta_victims := &schedulerapi.MetaVictims{
+			Pods: make([]*schedulerapi.MetaPod, 0, len(selected)),
+			NumPDBViolations: victims.NumPDBViolations,
+		}
+		for _, victim := range selected {
+			meta_victims.Pods = append(meta_victims.Pods, &schedulerapi.MetaPod{UID: string(victim.ObjectMeta.UID)})
+		}
+		result.NodeNameToMetaVictims[node_name] = meta_victims
+	}
+
+	return result, nil
+}
+
+// HandleSchedulerPreemptRequest is a callback function that processes incoming
+//	preempt requests to the RDMA scheduler extender.
+func HandleSchedulerPreemptRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	//reject empty requests
+	if(request.Body == nil) {
+		log.Println("Got empty http preempt request.")
+		http.Error(response, "Request body was empty.", 400)
+		return
+	}
+
+	var preemption_args schedulerapi.ExtenderPreemptionArgs
+	err := json.NewDecoder(request.Body).Decode(&preemption_args)
+	if(err != nil) {
+		log.Println("Got http request with malformatted scheduler extender preemption arguments.")
+		http.Error(response, err.Error(), 400)
+		return
+	}
+
+	log.Println("Got request to select preemption victims for pod: ", preemption_args.Pod.ObjectMeta.Name)
+	preemption_result, err := preemptForPod(request.Context(), &preemption_args)
+	if(err != nil) {
+		log.Println("Failed to select preemption victims: ", err)
+		http.Error(response, err.Error(), 500)
+		return
+	}
+
+	//serialize the results structure into a response
+	response_body, err := json.Marshal(preemption_result)
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
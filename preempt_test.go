@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//victimPods makes pods with the specified names.
+func victimPods(names ...string) []*v1.Pod {
+	pods := make([]*v1.Pod, len(names))
+	for i, name := range names {
+		pods[i] = &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	return pods
+}
+
+//freesAtLeast returns a check that a set of victims frees at least
+//	'needed', given how much each of them holds.
+func freesAtLeast(needed int, held map[string]int) func([]*v1.Pod) bool {
+	return func(set []*v1.Pod) bool {
+		freed := 0
+		for _, pod := range set {
+			freed += held[pod.ObjectMeta.Name]
+		}
+		return freed >= needed
+	}
+}
+
+func TestSmallestVictimSetBeatsReprieve(t *testing.T) {
+	//reprieving 'big' first leaves the three small victims, none of
+	//	which can be spared, while evicting 'big' alone is enough.
+	held := map[string]int{"small-1": 100, "small-2": 100, "small-3": 100, "big": 300}
+	victims := victimPods("small-1", "small-2", "small-3", "big")
+
+	set, found := smallestVictimSet(victims, 3, maxRdmaVictimSets, freesAtLeast(300, held))
+	if(!found) {
+		t.Fatal("expected a set smaller than 3 victims to be found")
+	}
+	if(len(set) != 1 || set[0].ObjectMeta.Name != "big") {
+		t.Fatalf("expected only 'big' to be evicted, got %v", set)
+	}
+}
+
+func TestSmallestVictimSetPrefersVictimsListedFirst(t *testing.T) {
+	held := map[string]int{"a": 100, "b": 100, "c": 100}
+	victims := victimPods("a", "b", "c")
+
+	set, found := smallestVictimSet(victims, 3, maxRdmaVictimSets, freesAtLeast(200, held))
+	if(!found) {
+		t.Fatal("expected a set of 2 victims to be found")
+	}
+	if(len(set) != 2 || set[0].ObjectMeta.Name != "a" || set[1].ObjectMeta.Name != "b") {
+		t.Fatalf("expected 'a' and 'b' to be evicted, got %v", set)
+	}
+}
+
+func TestSmallestVictimSetGivesUp(t *testing.T) {
+	held := map[string]int{"a": 100, "b": 100, "c": 100, "d": 100}
+	victims := victimPods("a", "b", "c", "d")
+
+	//no set smaller than the limit frees enough
+	_, found := smallestVictimSet(victims, 3, maxRdmaVictimSets, freesAtLeast(300, held))
+	if(found) {
+		t.Fatal("expected no set of fewer than 3 victims to be found")
+	}
+
+	//only as many sets as allowed are tried
+	tried := 0
+	_, found = smallestVictimSet(victims, 4, 5, func(set []*v1.Pod) bool {
+		tried++
+		return len(set) == 3
+	})
+	if(found || tried != 5) {
+		t.Fatalf("expected to give up after 5 sets, tried %d, found %v", tried, found)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+//scoring_policy rates how desirable a node is for a pod, given the result
+//	of placing the pod's RDMA interfaces on that node. higher values are
+//	better. the values are only compared against each other, they are
+//	scaled into the range expected by the k8s scheduler afterwards.
+type scoring_policy func(result node_eligibility) float64
+
+//the policies that can be selected through the 'SCORING_POLICY' environment
+//	variable or the 'placement.scoringPolicy' setting.
+var scoring_policies = map[string]scoring_policy{
+	"bin-pack": scoreBinPack,
+	"spread": scoreSpread,
+	"least-fragmenting": scoreLeastFragmenting,
+}
+
+//scoreBinPack prefers nodes that would have the least RDMA capacity left
+//	over after the pod is placed, so that pods are packed tightly and
+//	whole nodes are kept free for large requests.
+func scoreBinPack(result node_eligibility) float64 {
+	return -float64(result.capacity)
+}
+
+//scoreSpread prefers nodes that would have the most RDMA capacity left
+//	over after the pod is placed, spreading load across the cluster.
+func scoreSpread(result node_eligibility) float64 {
+	return float64(result.capacity)
+}
+
+//scoreLeastFragmenting prefers nodes that would still have the largest
+//	single block of bandwidth available on one PF (with a free VF to use
+//	it) after the pod is placed, so that later pods asking for a lot of
+//	bandwidth on one interface can still fit. the free bandwidth is
+//	worked out under the node's bandwidth policy, the same way the
+//	placement does.
+func scoreLeastFragmenting(result node_eligibility) float64 {
+	var largest_free_block int = 0
+	for i := range result.pfs {
+		free_tx_rate := result.policy.FreeBlock(&result.pfs[i])
+		if(free_tx_rate > largest_free_block) {
+			largest_free_block = free_tx_rate
+		}
+	}
+
+	return float64(largest_free_block)
+}
+
+//scoreNodes applies a scoring policy to the results of querying each
+//	potential node, then scales the scores into the range
+//	[0, schedulerapi.MaxPriority]. nodes that cannot fit the pod always
+//	get a score of 0.
+func scoreNodes(results []node_eligibility, policy scoring_policy) []int {
+	scores := make([]int, len(results))
+	raw_scores := make([]float64, len(results))
+
+	//find the range of raw scores among eligible nodes
+	var eligible_found bool = false
+	var min_raw, max_raw float64
+	for i, result := range results {
+		if(!result.enough_resources) {
+			continue
+		}
+		raw_scores[i] = policy(result)
+		if(!eligible_found || raw_scores[i] < min_raw) {
+			min_raw = raw_scores[i]
+		}
+		if(!eligible_found || raw_scores[i] > max_raw) {
+			max_raw = raw_scores[i]
+		}
+		eligible_found = true
+	}
+
+	//scale each eligible node's score into the range of the scheduler
+	for i, result := range results {
+		if(!result.enough_resources) {
+			continue
+		}
+		//if all eligible nodes are equally good, give them all the
+		//	highest score
+		if(max_raw == min_raw) {
+			scores[i] = schedulerapi.MaxPriority
+		} else {
+			scores[i] = int(float64(schedulerapi.MaxPriority) * (raw_scores[i] - min_raw) / (max_raw - min_raw))
+		}
+	}
+
+	return scores
+}
+
+// HandleSchedulerPrioritizeRequest is a callback function that processes
+//	incoming prioritize requests to the RDMA scheduler extender. it ranks
+//	the potential nodes for a pod according to the active scoring policy,
+//	and how close each node is to the other pods of the pod's job.
+func HandleSchedulerPrioritizeRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	//reject empty requests
+	if(request.Body == nil) {
+		log.Println("Got empty http prioritize request.")
+		http.Error(response, "Request body was empty.", 400)
+		return
+	}
+
+	//decode the scheduler extender input. this gives us both the list of
+	//	potential nodes to rank, and the details of the pod to be
+	//	scheduled.
+	var sched_extender_args schedulerapi.ExtenderArgs
+	err := json.NewDecoder(request.Body).Decode(&sched_extender_args)
+	if(err != nil) {
+		log.Println("Got http prioritize request with malformatted scheduler extender arguments.")
+		http.Error(response, err.Error(), 400)
+		return
+	}
+
+	log.Println("Got request to prioritize nodes for pod: ", sched_extender_args.Pod.ObjectMeta.Name)
+
+	//nodes missing from the local node cache are left unscored
+	nodes, _ := getPotentialNodes(&sched_extender_args)
+	host_priorities := make(schedulerapi.HostPriorityList, len(nodes))
+	for i, node := range nodes {
+		host_priorities[i].Host = node.Name
+	}
+
+	//pods without (valid) RDMA requirements get the same score on every
+	//	node, so they are ranked by the core scheduler alone.
+	audit := newAuditRecord("prioritize", sched_extender_args.Pod)
+	interfaces_needed, constraints, err := parseRdmaInterfacesRequired(sched_extender_args.Pod)
+	if(err != nil || len(interfaces_needed) == 0) {
+		log.Println("Pod doesn't require any RDMA interfaces. All nodes will get the same score.")
+		audit.Verdict = auditVerdictNoRdmaNeeded
+		if(err != nil) {
+			audit.Verdict = auditVerdictInvalidRequest
+			audit.Error = err.Error()
+		}
+	} else {
+		//check every potential node, with the results in the same
+		//	order as the list of nodes.
+		results := evaluateNodes(request.Context(), nodes, sched_extender_args.Pod.ObjectMeta.UID, interfaces_needed, constraints)
+		audit.Interfaces = interfaces_needed
+		audit.Nodes = auditNodeResults(nodes, results)
+		audit.Verdict = auditVerdictScored
+
+		//nodes close on the fabric to the other pods of the same
+		//	job are favoured
+		scores := scoreNodes(results, scoring_policies[currentConfig().Placement.ScoringPolicy])
+		weighTopology(scores, results, nodes, jobPeerNodes(sched_extender_args.Pod))
+
+		log.Println("Node scores:")
+		for i, score := range scores {
+			host_priorities[i].Score = score
+			audit.Nodes[i].Score = &host_priorities[i].Score
+			log.Println("\t", host_priorities[i].Host, ": ", score)
+		}
+	}
+	audit_log.record(audit)
+
+	//serialize the list of scores into a response
+	response_body, err := json.Marshal(host_priorities)
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
@@ -0,0 +1,562 @@
+package rdma_placement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-common/rdma_hardware_info"
+)
+
+//BandwidthMode determines which of the tx rates of a requested RDMA interface
+//	are counted against the bandwidth of the PF it is placed on.
+type BandwidthMode string
+
+const (
+	//the sum of the max tx rates of the interfaces on a PF may not exceed
+	//	the PF's capacity, so every interface can always reach its max.
+	GuaranteedMode BandwidthMode = "guaranteed"
+	//the sum of the min tx rates of the interfaces on a PF may not exceed
+	//	the PF's capacity, and the sum of their max tx rates may not
+	//	exceed the PF's capacity times an oversubscription ratio.
+	BurstableMode BandwidthMode = "burstable"
+	//only free VFs are needed, bandwidth is shared on a best-effort basis.
+	BestEffortMode BandwidthMode = "best-effort"
+)
+
+//BandwidthPolicy describes how bandwidth on a node's PFs is handed out.
+type BandwidthPolicy struct {
+	Mode BandwidthMode
+	//only used in burstable mode.
+	OversubscriptionRatio float64
+}
+
+//ParseBandwidthMode checks that a string names a known bandwidth mode.
+func ParseBandwidthMode(mode string) (BandwidthMode, error) {
+	switch BandwidthMode(mode) {
+	case GuaranteedMode, BurstableMode, BestEffortMode:
+		return BandwidthMode(mode), nil
+	}
+
+	return "", fmt.Errorf("unknown bandwidth mode '%s', expected one of: %s, %s, %s", mode, GuaranteedMode, BurstableMode, BestEffortMode)
+}
+
+//String describes a policy in the form used in scheduling failure reasons.
+func (policy BandwidthPolicy) String() string {
+	if policy.Mode == BurstableMode {
+		return fmt.Sprintf("%s, oversubscription ratio %g", policy.Mode, policy.OversubscriptionRatio)
+	}
+	return string(policy.Mode)
+}
+
+//NumaPolicy determines how a pod's interfaces are placed with respect to the
+//	NUMA nodes their PFs are attached to.
+type NumaPolicy string
+
+const (
+	//interfaces may go on any PF, whatever its NUMA node.
+	NoNumaPolicy NumaPolicy = ""
+	//all of the interfaces go on PFs attached to the same NUMA node.
+	SingleNumaNodePolicy NumaPolicy = "single-numa-node"
+)
+
+//NumaAffinity describes the NUMA placement rule a pod asked for.
+type NumaAffinity struct {
+	Policy NumaPolicy
+	//the NUMA node the interfaces must go on, or nil if any single NUMA
+	//	node will do.
+	Node *int
+}
+
+//DistinctConstraint keeps two groups of a pod's interfaces off each other's
+//	PFs: no interface in First may share a PF with any interface in
+//	Second. interfaces are given by their index in the list of requested
+//	interfaces.
+type DistinctConstraint struct {
+	First []int
+	Second []int
+	//describes the rule in the terms the pod asked for it, for use in
+	//	scheduling failure reasons
+	Description string
+}
+
+//NetworkConstraint keeps a group of a pod's interfaces on PFs cabled to
+//	the named network, given as '<namespace>/<name>' or just a name. a
+//	PF whose network has no namespace is on the network of that name in
+//	every namespace.
+type NetworkConstraint struct {
+	Interfaces []int
+	Network string
+	Description string
+}
+
+//SpreadConstraint spreads a group of a pod's interfaces across at least
+//	MinPFs different PFs.
+type SpreadConstraint struct {
+	Interfaces []int
+	MinPFs int
+	Description string
+}
+
+//Constraints are the rules a pod's interfaces must be placed by, on top of
+//	fitting on their PFs.
+type Constraints struct {
+	Numa NumaAffinity
+	Networks []NetworkConstraint
+	Distinct []DistinctConstraint
+	Spreads []SpreadConstraint
+}
+
+//Empty determines whether there are no rules to keep to.
+func (constraints Constraints) Empty() bool {
+	return constraints.Numa.Policy == NoNumaPolicy && len(constraints.Networks) == 0 && len(constraints.Distinct) == 0 && len(constraints.Spreads) == 0
+}
+
+//ConstraintKind names the kind of rule that kept a pod's interfaces from
+//	being placed.
+type ConstraintKind string
+
+const (
+	NetworkAttachment ConstraintKind = "network"
+	NumaConstraint ConstraintKind = "numa"
+	PFConstraint ConstraintKind = "pf"
+)
+
+//pf_rules is the form of the network, PF anti-affinity and spread rules
+//	used during the placement search, indexed by interface.
+type pf_rules struct {
+	//for each interface, the network its PF must be cabled to, or empty
+	//	if any PF will do
+	networks []string
+	//for each interface, the interfaces that may not share its PF
+	conflicts [][]int
+	//for each interface, the spread rules it is part of
+	spreads [][]*SpreadConstraint
+}
+
+//newPFRules indexes the network, PF anti-affinity and spread rules of a pod with
+//	the specified number of interfaces. interfaces outside that range are
+//	ignored.
+func newPFRules(constraints Constraints, interface_count int) *pf_rules {
+	rules := &pf_rules{
+		networks: make([]string, interface_count),
+		conflicts: make([][]int, interface_count),
+		spreads: make([][]*SpreadConstraint, interface_count),
+	}
+	in_range := func(index int) bool {
+		return index >= 0 && index < interface_count
+	}
+
+	for _, network := range constraints.Networks {
+		for _, index := range network.Interfaces {
+			if in_range(index) {
+				rules.networks[index] = network.Network
+			}
+		}
+	}
+	for _, distinct := range constraints.Distinct {
+		for _, first := range distinct.First {
+			for _, second := range distinct.Second {
+				if in_range(first) && in_range(second) && first != second {
+					rules.conflicts[first] = append(rules.conflicts[first], second)
+					rules.conflicts[second] = append(rules.conflicts[second], first)
+				}
+			}
+		}
+	}
+	for i := range constraints.Spreads {
+		for _, index := range constraints.Spreads[i].Interfaces {
+			if in_range(index) {
+				rules.spreads[index] = append(rules.spreads[index], &constraints.Spreads[i])
+			}
+		}
+	}
+
+	return rules
+}
+
+//onNetwork determines whether a PF is cabled to a network, as named by a
+//	NetworkConstraint.
+func (pf *PF) onNetwork(network string) bool {
+	if pf.Network == network {
+		return true
+	}
+	if strings.Contains(pf.Network, "/") {
+		return false
+	}
+	return network[strings.Index(network, "/") + 1:] == pf.Network
+}
+
+//allows determines whether an interface may be placed on a PF, given where
+//	the interfaces before it were placed. a spread rule is broken as soon
+//	as the interfaces left to place can no longer reach enough PFs.
+func (rules *pf_rules) allows(interface_index int, pf_index int, pf *PF, placements []int) bool {
+	if rules.networks[interface_index] != "" && !pf.onNetwork(rules.networks[interface_index]) {
+		return false
+	}
+
+	for _, other := range rules.conflicts[interface_index] {
+		if other < interface_index && placements[other] == pf_index {
+			return false
+		}
+	}
+
+	for _, spread := range rules.spreads[interface_index] {
+		used_pfs := map[int]bool{pf_index: true}
+		remaining := 0
+		for _, index := range spread.Interfaces {
+			if index < interface_index {
+				used_pfs[placements[index]] = true
+			} else if index > interface_index {
+				remaining++
+			}
+		}
+		if len(used_pfs) + remaining < spread.MinPFs {
+			return false
+		}
+	}
+
+	return true
+}
+
+//positional determines whether any of the rules depend on which of a
+//	pod's interfaces share a PF, rather than only on the PFs themselves.
+func (rules *pf_rules) positional() bool {
+	for index := range rules.conflicts {
+		if len(rules.conflicts[index]) > 0 || len(rules.spreads[index]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//interchangeable determines whether two interfaces are bound by the same
+//	rules, so that swapping the PFs they are placed on keeps to the rules
+//	just as well.
+func (rules *pf_rules) interchangeable(first int, second int) bool {
+	if rules.networks[first] != rules.networks[second] || len(rules.spreads[first]) != len(rules.spreads[second]) {
+		return false
+	}
+	for index := range rules.spreads[first] {
+		if rules.spreads[first][index] != rules.spreads[second][index] {
+			return false
+		}
+	}
+
+	//the interfaces may conflict with each other, but otherwise must
+	//	conflict with the same interfaces
+	conflicts := func(index int, other int) map[int]bool {
+		set := make(map[int]bool)
+		for _, conflict := range rules.conflicts[index] {
+			if conflict != other {
+				set[conflict] = true
+			}
+		}
+		return set
+	}
+	first_conflicts := conflicts(first, second)
+	second_conflicts := conflicts(second, first)
+	if len(first_conflicts) != len(second_conflicts) {
+		return false
+	}
+	for conflict := range first_conflicts {
+		if !second_conflicts[conflict] {
+			return false
+		}
+	}
+	return true
+}
+
+//ReportedPF is a PF as its RDMA hardware DaemonSet reports it. on top of the
+//	fields of rdma_hardware_info.PF, DaemonSets that know which NUMA node
+//	a PF is attached to report it as 'numa_node', and DaemonSets that know
+//	which network (or fabric) a PF is cabled to report it as 'network'.
+//	NumaNode is nil, and Network empty, for PFs whose DaemonSet doesn't
+//	report them.
+type ReportedPF struct {
+	rdma_hardware_info.PF
+	NumaNode *int `json:"numa_node,omitempty"`
+	Network string `json:"network,omitempty"`
+}
+
+//PF is the state of a PF that placement decisions are made against: what the
+//	RDMA hardware DaemonSet reported, plus the sum of the max tx rates of
+//	the VFs in use on it.
+type PF struct {
+	ReportedPF
+	UsedMaxTxRate uint
+}
+
+//EffectiveMaxTxRate returns the rate an interface (or VF) may burst to. a max
+//	tx rate below the min tx rate (including an unset one) means the
+//	interface never goes above its min tx rate.
+func EffectiveMaxTxRate(min_tx_rate uint, max_tx_rate uint) uint {
+	if max_tx_rate < min_tx_rate {
+		return min_tx_rate
+	}
+	return max_tx_rate
+}
+
+//FromReported builds the placement state of a node's PFs from what its
+//	RDMA hardware DaemonSet reported.
+func FromReported(reported_pfs []ReportedPF) []PF {
+	pfs := make([]PF, len(reported_pfs))
+	for index, reported_pf := range reported_pfs {
+		pfs[index].ReportedPF = reported_pf
+		for _, vf := range reported_pf.VFs {
+			if vf != nil && vf.Allocated {
+				pfs[index].UsedMaxTxRate += EffectiveMaxTxRate(vf.MinTxRate, vf.MaxTxRate)
+			}
+		}
+		//the max tx rates in use can never add up to less than the
+		//	min tx rates in use
+		if pfs[index].UsedMaxTxRate < reported_pf.UsedTxRate {
+			pfs[index].UsedMaxTxRate = reported_pf.UsedTxRate
+		}
+	}
+
+	return pfs
+}
+
+//fits determines whether a requested interface can be placed on a PF under
+//	the policy.
+func (policy BandwidthPolicy) fits(pf *PF, request *knapsack_pod_placement.RdmaInterfaceRequest) bool {
+	if (int(pf.CapacityVFs) - int(pf.UsedVFs)) <= 0 {
+		return false
+	}
+
+	max_tx_rate := EffectiveMaxTxRate(request.MinTxRate, request.MaxTxRate)
+	switch policy.Mode {
+	case BestEffortMode:
+		return true
+	case GuaranteedMode:
+		return (int(pf.CapacityTxRate) - int(pf.UsedMaxTxRate)) >= int(max_tx_rate)
+	default:
+		if (int(pf.CapacityTxRate) - int(pf.UsedTxRate)) < int(request.MinTxRate) {
+			return false
+		}
+		return float64(pf.UsedMaxTxRate + max_tx_rate) <= float64(pf.CapacityTxRate) * policy.OversubscriptionRatio
+	}
+}
+
+//Take adds the resources used by an interface to a PF.
+func (pf *PF) Take(min_tx_rate uint, max_tx_rate uint) {
+	pf.UsedVFs += 1
+	pf.UsedTxRate += min_tx_rate
+	pf.UsedMaxTxRate += EffectiveMaxTxRate(min_tx_rate, max_tx_rate)
+}
+
+//Give removes the resources used by an interface from a PF.
+func (pf *PF) Give(min_tx_rate uint, max_tx_rate uint) {
+	max_tx_rate = EffectiveMaxTxRate(min_tx_rate, max_tx_rate)
+	if pf.UsedVFs > 0 {
+		pf.UsedVFs -= 1
+	}
+	if pf.UsedTxRate > min_tx_rate {
+		pf.UsedTxRate -= min_tx_rate
+	} else {
+		pf.UsedTxRate = 0
+	}
+	if pf.UsedMaxTxRate > max_tx_rate {
+		pf.UsedMaxTxRate -= max_tx_rate
+	} else {
+		pf.UsedMaxTxRate = 0
+	}
+}
+
+//PlacePod takes in a list of required RDMA interfaces and a list of PFs
+//	available on a node and determines whether the unused bandwidth and
+//	VFs on that node can satisfy all of the required RDMA interfaces in
+//	the list under the specified bandwidth policy. It returns the amount
+//	of bandwidth (by min tx rate) left free on the node afterwards, a list
+//	of indicies representing which PF each of the required interfaces was
+//	placed on, and a boolean flag indicating whether the request can be
+//	satisfied (if it can't, the list of indicies will be empty). When the
+//	request is satisfied, the PFs are left with the placed interfaces
+//	added to their usage.
+//
+//	Pod placement finds the same placement as the backtracking algorithm
+//	of knapsack_pod_placement.PlacePod, but skips over placements that
+//	only differ by swapping identical PFs or identical interfaces.
+func PlacePod(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs_available []PF,
+	policy BandwidthPolicy,
+	debug_logging bool) (int, []int, bool) {
+
+	//without a deadline the search never times out
+	capacity, placements, placement_success, _ := placePod(requested_interfaces, pfs_available, policy, newPFRules(Constraints{}, len(requested_interfaces)), Solver{}, time.Time{}, debug_logging)
+	return capacity, placements, placement_success
+}
+
+//PlacePodWithConstraints is PlacePod for a pod that asked for placement
+//	rules. interfaces that name a network only go on PFs cabled to it,
+//	and interfaces are kept off each other's PFs, and spread across PFs,
+//	as the rules say. with a NUMA rule, all of the interfaces are
+//	placed on PFs attached to a single NUMA node: the one the rule names,
+//	or otherwise the lowest numbered NUMA node they fit on. PFs whose
+//	NUMA node isn't known are never used for such pods. the returned
+//	indices refer to the full list of PFs. which placement is chosen, and
+//	how long is spent looking for it, is up to the solver. if the solver
+//	runs out of time before finding a placement, ErrSolverTimedOut is
+//	returned along with the failure.
+func PlacePodWithConstraints(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs_available []PF,
+	policy BandwidthPolicy,
+	constraints Constraints,
+	solver Solver,
+	debug_logging bool) (int, []int, bool, error) {
+
+	return placePodWithConstraints(requested_interfaces, pfs_available, policy, constraints, solver, solver.deadline(), debug_logging)
+}
+
+//placePodWithConstraints is PlacePodWithConstraints, with the search
+//	ending by the specified deadline rather than the solver's budget.
+func placePodWithConstraints(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs_available []PF,
+	policy BandwidthPolicy,
+	constraints Constraints,
+	solver Solver,
+	deadline time.Time,
+	debug_logging bool) (int, []int, bool, error) {
+
+	rules := newPFRules(constraints, len(requested_interfaces))
+	affinity := constraints.Numa
+	if affinity.Policy == NoNumaPolicy || len(requested_interfaces) <= 0 {
+		return placePod(requested_interfaces, pfs_available, policy, rules, solver, deadline, debug_logging)
+	}
+
+	//find the NUMA nodes the interfaces may go on
+	var numa_nodes []int
+	if affinity.Node != nil {
+		numa_nodes = []int{*affinity.Node}
+	} else {
+		seen := make(map[int]bool)
+		for _, pf := range pfs_available {
+			if pf.NumaNode != nil && !seen[*pf.NumaNode] {
+				seen[*pf.NumaNode] = true
+				numa_nodes = append(numa_nodes, *pf.NumaNode)
+			}
+		}
+		sort.Ints(numa_nodes)
+	}
+
+	//try placing all of the interfaces on the PFs of each NUMA node in
+	//	turn
+	var search_err error
+	for _, numa_node := range numa_nodes {
+		var indices []int
+		var numa_pfs []PF
+		for index, pf := range pfs_available {
+			if pf.NumaNode != nil && *pf.NumaNode == numa_node {
+				indices = append(indices, index)
+				numa_pfs = append(numa_pfs, pf)
+			}
+		}
+		if len(numa_pfs) == 0 {
+			continue
+		}
+
+		_, placements, placement_success, err := placePod(requested_interfaces, numa_pfs, policy, rules, solver, deadline, debug_logging)
+		if !placement_success {
+			if err != nil {
+				search_err = err
+			}
+			continue
+		}
+
+		//carry the usage and indices back over to the full list of PFs
+		for i, index := range indices {
+			pfs_available[index] = numa_pfs[i]
+		}
+		for i := range placements {
+			placements[i] = indices[placements[i]]
+		}
+		return freeTxRate(pfs_available), placements, true, nil
+	}
+
+	return freeTxRate(pfs_available), []int{}, false, search_err
+}
+
+//UnmetConstraint works out which of a pod's placement rules keeps its
+//	interfaces from being placed on a node's PFs, when they would fit
+//	there without any rules. it returns the kind of rule and its
+//	description, or an empty kind if the interfaces fit, or don't fit
+//	even without rules. all of the attempts it makes share the solver's
+//	budget, and ErrSolverTimedOut is returned if that runs out before the
+//	rule is found. the PFs are left untouched.
+func UnmetConstraint(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs_available []PF,
+	policy BandwidthPolicy,
+	constraints Constraints,
+	solver Solver) (ConstraintKind, string, error) {
+
+	//only whether the interfaces fit matters here, not how well
+	deadline := solver.deadline()
+	solver = solver.FirstFit()
+	var search_err error
+	fits := func(partial Constraints) bool {
+		if search_err != nil {
+			return false
+		}
+		pfs := append([]PF(nil), pfs_available...)
+		_, _, placement_success, err := placePodWithConstraints(requested_interfaces, pfs, policy, partial, solver, deadline, false)
+		search_err = err
+		return placement_success
+	}
+	kind, description := unmetConstraint(constraints, fits)
+	if search_err != nil {
+		return "", "", search_err
+	}
+	return kind, description, nil
+}
+
+//unmetConstraint is UnmetConstraint, using 'fits' to try whether the
+//	interfaces fit under a subset of the rules.
+func unmetConstraint(constraints Constraints, fits func(Constraints) bool) (ConstraintKind, string) {
+	if !fits(Constraints{}) || fits(constraints) {
+		return "", ""
+	}
+
+	//add the rules back one at a time, until the pod no longer fits
+	var partial Constraints
+	for _, network := range constraints.Networks {
+		partial.Networks = append(partial.Networks, network)
+		if !fits(partial) {
+			return NetworkAttachment, network.Description
+		}
+	}
+	partial.Numa = constraints.Numa
+	if partial.Numa.Policy != NoNumaPolicy && !fits(partial) {
+		if partial.Numa.Node != nil {
+			return NumaConstraint, fmt.Sprintf("NUMA policy %s on NUMA node %d", partial.Numa.Policy, *partial.Numa.Node)
+		}
+		return NumaConstraint, fmt.Sprintf("NUMA policy %s", partial.Numa.Policy)
+	}
+	for _, distinct := range constraints.Distinct {
+		partial.Distinct = append(partial.Distinct, distinct)
+		if !fits(partial) {
+			return PFConstraint, distinct.Description
+		}
+	}
+	for _, spread := range constraints.Spreads {
+		partial.Spreads = append(partial.Spreads, spread)
+		if !fits(partial) {
+			return PFConstraint, spread.Description
+		}
+	}
+
+	return PFConstraint, "the combination of the pod's PF rules"
+}
+
+//freeTxRate adds up the bandwidth (by min tx rate) left free on a node's PFs.
+func freeTxRate(pfs []PF) int {
+	free := 0
+	for _, pf := range pfs {
+		if pf.CapacityTxRate > pf.UsedTxRate {
+			free += int(pf.CapacityTxRate - pf.UsedTxRate)
+		}
+	}
+	return free
+}
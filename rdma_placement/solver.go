@@ -0,0 +1,476 @@
+package rdma_placement
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+)
+
+//PlacementGoal determines which of the ways a pod's interfaces could be
+//	placed on a node's PFs is chosen.
+type PlacementGoal string
+
+const (
+	//the first placement found, trying each interface on the PFs in the
+	//	order they were reported. this is the placement the original
+	//	backtracking algorithm finds.
+	FirstFitGoal PlacementGoal = "first-fit"
+	//the placement leaving the largest single block of bandwidth free on
+	//	one PF (with a free VF to use it).
+	LeastFragmentingGoal PlacementGoal = "least-fragmenting"
+	//the placement leaving the load spread most evenly across the PFs.
+	BalancedGoal PlacementGoal = "balanced"
+	//the placement leaving the most bandwidth free on PFs that still have
+	//	a free VF, so that as little bandwidth as possible is stranded.
+	MostFreeBandwidthGoal PlacementGoal = "most-free-bandwidth"
+)
+
+//ParsePlacementGoal checks that a string names a known placement goal.
+func ParsePlacementGoal(goal string) (PlacementGoal, error) {
+	switch PlacementGoal(goal) {
+	case FirstFitGoal, LeastFragmentingGoal, BalancedGoal, MostFreeBandwidthGoal:
+		return PlacementGoal(goal), nil
+	}
+
+	return "", fmt.Errorf("unknown placement goal '%s', expected one of: %s, %s, %s, %s", goal, FirstFitGoal, LeastFragmentingGoal, BalancedGoal, MostFreeBandwidthGoal)
+}
+
+//ErrSolverTimedOut is returned when the search for a placement runs out of
+//	the solver's budget before finding one, so the interfaces may still
+//	fit given longer.
+var ErrSolverTimedOut = errors.New("ran out of time searching for a placement")
+
+//Solver describes how the placement of a pod's interfaces is searched for.
+//	the zero value finds the first placement, taking as long as needed.
+type Solver struct {
+	Goal PlacementGoal
+	//how long the search for one node may take. when it runs out, the
+	//	best placement found so far is used, or ErrSolverTimedOut is
+	//	returned if none was found yet. zero means no limit.
+	Budget time.Duration
+	//when set, every search made with the solver ends by this time
+	//	instead, so that they share one budget. see SharedBudget.
+	Deadline time.Time
+}
+
+//FirstFit returns the solver with its goal set to finding the first
+//	placement, for when only whether a pod fits matters.
+func (solver Solver) FirstFit() Solver {
+	solver.Goal = FirstFitGoal
+	return solver
+}
+
+//SharedBudget returns the solver with its budget starting now, so that all
+//	of the searches made with it share the one budget.
+func (solver Solver) SharedBudget() Solver {
+	solver.Deadline = solver.deadline()
+	return solver
+}
+
+//deadline returns the time a search starting now must end by, or the zero
+//	time if there is no limit.
+func (solver Solver) deadline() time.Time {
+	if !solver.Deadline.IsZero() {
+		return solver.Deadline
+	}
+	if solver.Budget <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(solver.Budget)
+}
+
+//FreeBlock returns the bandwidth an interface placed on a PF could still
+//	be given under the policy, or 0 if the PF has no free VF.
+func (policy BandwidthPolicy) FreeBlock(pf *PF) int {
+	if pf.CapacityVFs <= pf.UsedVFs {
+		return 0
+	}
+	if policy.Mode == GuaranteedMode {
+		return int(pf.CapacityTxRate) - int(pf.UsedMaxTxRate)
+	}
+	return int(pf.CapacityTxRate) - int(pf.UsedTxRate)
+}
+
+//score rates how well a complete placement, given as the state of the PFs
+//	with the pod's interfaces on them, meets the goal. higher values are
+//	better.
+func (goal PlacementGoal) score(pfs []PF, policy BandwidthPolicy) float64 {
+	var score float64 = 0
+	for i := range pfs {
+		pf := &pfs[i]
+		switch goal {
+		case LeastFragmentingGoal:
+			if block := float64(policy.FreeBlock(pf)); block > score {
+				score = block
+			}
+		case BalancedGoal:
+			//the sum of the squares of each PF's use of its
+			//	bandwidth and VFs is lowest when they are evenly
+			//	used
+			if pf.CapacityTxRate > 0 {
+				used := float64(pf.UsedTxRate) / float64(pf.CapacityTxRate)
+				score -= used * used
+			}
+			if pf.CapacityVFs > 0 {
+				used := float64(pf.UsedVFs) / float64(pf.CapacityVFs)
+				score -= used * used
+			}
+		case MostFreeBandwidthGoal:
+			if block := policy.FreeBlock(pf); block > 0 {
+				score += float64(block)
+			}
+		}
+	}
+	return score
+}
+
+//pf_class identifies PFs that are the same as far as placement is
+//	concerned, apart from what is in use on them.
+type pf_class struct {
+	capacity_vfs uint
+	capacity_tx_rate uint
+	numa_node int
+	numa_known bool
+	network string
+}
+
+//solver_search holds the state of one search for the placement of a pod's
+//	interfaces on a set of PFs.
+type solver_search struct {
+	requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest
+	pfs []PF
+	policy BandwidthPolicy
+	rules *pf_rules
+	goal PlacementGoal
+	deadline time.Time
+	debug_logging bool
+
+	//the PF each interface is on, -1 while it isn't placed
+	placements []int
+	//for each interface, whether it can be swapped with the one before
+	//	it without changing anything
+	same_as_previous []bool
+	//the class of each PF
+	classes []int
+	//for each PF, which of the pod's interfaces are on it. only kept
+	//	when the PF rules depend on which interfaces share a PF.
+	interface_masks []uint64
+	//whether PFs in the same state can be told apart by which of the
+	//	pod's interfaces are on them
+	positional bool
+	//whether equivalent PFs may be skipped at all
+	prune_pfs bool
+	//for each interface, the order its candidate PFs are tried in
+	orders [][]int
+	//for each interface, the bandwidth taken by it and the interfaces
+	//	after it
+	remaining_rates []int
+
+	found bool
+	best_score float64
+	best_placements []int
+	steps int
+	expired bool
+}
+
+//newSolverSearch sets up a search, working out which PFs and which
+//	interfaces are interchangeable.
+func newSolverSearch(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs []PF,
+	policy BandwidthPolicy,
+	rules *pf_rules,
+	solver Solver,
+	deadline time.Time,
+	debug_logging bool) *solver_search {
+
+	search := &solver_search{
+		requested_interfaces: requested_interfaces,
+		pfs: pfs,
+		policy: policy,
+		rules: rules,
+		goal: solver.Goal,
+		deadline: deadline,
+		debug_logging: debug_logging,
+		placements: make([]int, len(requested_interfaces)),
+		orders: make([][]int, len(requested_interfaces)),
+		remaining_rates: make([]int, len(requested_interfaces) + 1),
+		same_as_previous: make([]bool, len(requested_interfaces)),
+		classes: make([]int, len(pfs)),
+		interface_masks: make([]uint64, len(pfs)),
+		positional: rules.positional(),
+	}
+	if search.goal == "" {
+		search.goal = FirstFitGoal
+	}
+	//which interfaces are on a PF can only be tracked for up to 64
+	//	interfaces
+	search.prune_pfs = !search.positional || len(requested_interfaces) <= 64
+
+	for index := len(requested_interfaces) - 1; index >= 0; index-- {
+		rate := requested_interfaces[index].MinTxRate
+		if policy.Mode == GuaranteedMode {
+			rate = EffectiveMaxTxRate(requested_interfaces[index].MinTxRate, requested_interfaces[index].MaxTxRate)
+		}
+		search.remaining_rates[index] = search.remaining_rates[index + 1] + int(rate)
+	}
+	for index := range search.placements {
+		search.placements[index] = -1
+		search.orders[index] = make([]int, 0, len(pfs))
+		if index > 0 {
+			search.same_as_previous[index] = requested_interfaces[index] == requested_interfaces[index - 1] && rules.interchangeable(index - 1, index)
+		}
+	}
+
+	class_ids := make(map[pf_class]int)
+	for index := range pfs {
+		class := pf_class{
+			capacity_vfs: pfs[index].CapacityVFs,
+			capacity_tx_rate: pfs[index].CapacityTxRate,
+			network: pfs[index].Network,
+		}
+		if pfs[index].NumaNode != nil {
+			class.numa_known = true
+			class.numa_node = *pfs[index].NumaNode
+		}
+		id, found := class_ids[class]
+		if !found {
+			id = len(class_ids)
+			class_ids[class] = id
+		}
+		search.classes[index] = id
+	}
+
+	return search
+}
+
+//equivalent determines whether two PFs are interchangeable at this point
+//	of the search: swapping everything placed on them, now and later,
+//	would give a placement that is just as valid and just as good.
+func (search *solver_search) equivalent(first int, second int) bool {
+	if search.classes[first] != search.classes[second] {
+		return false
+	}
+	first_pf := &search.pfs[first]
+	second_pf := &search.pfs[second]
+	if first_pf.UsedVFs != second_pf.UsedVFs || first_pf.UsedTxRate != second_pf.UsedTxRate || first_pf.UsedMaxTxRate != second_pf.UsedMaxTxRate {
+		return false
+	}
+	return !search.positional || search.interface_masks[first] == search.interface_masks[second]
+}
+
+//outOfTime determines whether the search has run past its deadline. the
+//	clock is only read every so often, since reading it costs more than
+//	a step of the search.
+func (search *solver_search) outOfTime() bool {
+	if search.expired {
+		return true
+	}
+	search.steps++
+	if search.deadline.IsZero() || search.steps % 256 != 0 {
+		return false
+	}
+	if time.Now().After(search.deadline) {
+		search.expired = true
+		if search.debug_logging {
+			log.Println("Placement search ran out of time after ", search.steps, " steps, placement found: ", search.found)
+		}
+	}
+	return search.expired
+}
+
+//bound returns a score no placement reachable from this point of the
+//	search can beat, so that branches that can't improve on the best
+//	placement found are cut short.
+func (search *solver_search) bound(index int) float64 {
+	switch search.goal {
+	case LeastFragmentingGoal:
+		//free blocks only shrink as interfaces are placed
+		largest := 0
+		for i := range search.pfs {
+			if block := search.policy.FreeBlock(&search.pfs[i]); block > largest {
+				largest = block
+			}
+		}
+		return float64(largest)
+	case MostFreeBandwidthGoal:
+		//each interface left takes at least its bandwidth away from
+		//	the free blocks, unless bandwidth isn't counted at all
+		if search.policy.Mode == BestEffortMode {
+			break
+		}
+		free := 0
+		for i := range search.pfs {
+			if block := search.policy.FreeBlock(&search.pfs[i]); block > 0 {
+				free += block
+			}
+		}
+		return float64(free - search.remaining_rates[index])
+	}
+	return math.Inf(1)
+}
+
+//candidates returns the PFs from 'first_pf' on, in the order they should
+//	be tried for the interface at the specified index. with the first-fit
+//	goal that is the order they were reported in. otherwise PFs likely to
+//	lead to a good placement are tried first, so that the best placement
+//	is found early and more of the search can be cut short: the least
+//	used PFs to balance load, or the fullest PFs the interface fits on to
+//	keep large blocks free.
+func (search *solver_search) candidates(index int, first_pf int) []int {
+	order := search.orders[index][:0]
+	for pf_index := first_pf; pf_index < len(search.pfs); pf_index++ {
+		order = append(order, pf_index)
+	}
+
+	switch search.goal {
+	case BalancedGoal:
+		use := func(pf *PF) float64 {
+			if pf.CapacityTxRate == 0 {
+				return 0
+			}
+			return float64(pf.UsedTxRate) / float64(pf.CapacityTxRate)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return use(&search.pfs[order[i]]) < use(&search.pfs[order[j]])
+		})
+	case LeastFragmentingGoal, MostFreeBandwidthGoal:
+		sort.SliceStable(order, func(i, j int) bool {
+			return search.policy.FreeBlock(&search.pfs[order[i]]) < search.policy.FreeBlock(&search.pfs[order[j]])
+		})
+	}
+
+	search.orders[index] = order
+	return order
+}
+
+//done determines whether the search can stop.
+func (search *solver_search) done() bool {
+	return search.expired || (search.found && search.goal == FirstFitGoal)
+}
+
+//place tries every PF the interface at the specified index could go on,
+//	then continues with the next interface. PFs that are equivalent to a
+//	PF already tried for the interface are skipped, as are placements
+//	that only reorder identical interfaces.
+func (search *solver_search) place(index int) {
+	if search.outOfTime() {
+		return
+	}
+
+	//every interface is placed, so keep the placement if it is the best
+	//	one so far
+	if index == len(search.requested_interfaces) {
+		score := search.goal.score(search.pfs, search.policy)
+		if !search.found || score > search.best_score {
+			search.found = true
+			search.best_score = score
+			search.best_placements = append(search.best_placements[:0], search.placements...)
+			if search.debug_logging {
+				log.Println("Found placement ", search.placements, " with score ", score)
+			}
+		}
+		return
+	}
+
+	if search.found && search.bound(index) <= search.best_score {
+		return
+	}
+
+	request := &search.requested_interfaces[index]
+	first_pf := 0
+	if search.same_as_previous[index] {
+		first_pf = search.placements[index - 1]
+	}
+	for _, pf_index := range search.candidates(index, first_pf) {
+		pf := &search.pfs[pf_index]
+		if !search.policy.fits(pf, request) || !search.rules.allows(index, pf_index, pf, search.placements) {
+			continue
+		}
+		if search.prune_pfs && search.hasEquivalent(first_pf, pf_index) {
+			continue
+		}
+
+		search.placements[index] = pf_index
+		pf.Take(request.MinTxRate, request.MaxTxRate)
+		if search.positional {
+			search.interface_masks[pf_index] |= 1 << uint(index)
+		}
+
+		search.place(index + 1)
+
+		if search.positional {
+			search.interface_masks[pf_index] &^= 1 << uint(index)
+		}
+		pf.Give(request.MinTxRate, request.MaxTxRate)
+		search.placements[index] = -1
+
+		if search.done() {
+			return
+		}
+	}
+}
+
+//hasEquivalent determines whether a PF is equivalent to one of the PFs
+//	from 'first_pf' up to it. of a set of equivalent PFs, only the lowest
+//	numbered one is tried, whatever order the PFs are tried in.
+func (search *solver_search) hasEquivalent(first_pf int, pf_index int) bool {
+	for other := first_pf; other < pf_index; other++ {
+		if search.equivalent(other, pf_index) {
+			return true
+		}
+	}
+	return false
+}
+
+//placePod is PlacePod, with the search also keeping to the network, PF
+//	anti-affinity and spread rules, and choosing between placements as
+//	the solver says.
+//
+//	The search tries each interface on each PF in turn, backtracking when
+//	an interface fits nowhere, like knapsack_pod_placement.PlacePod. To
+//	keep it from trying the same placement many times over, a PF is
+//	skipped when another PF already tried for the same interface is in
+//	the same state, and identical interfaces in a row are only placed on
+//	PFs in ascending order. With the first-fit goal the search stops at
+//	the first placement found. With other goals it goes on to find the
+//	best placement within the solver's time budget, trying the PFs most
+//	likely to lead to a good placement first, and cutting short branches
+//	that can't beat the best placement found. If the deadline passes
+//	before any placement is found, ErrSolverTimedOut is returned.
+func placePod(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs_available []PF,
+	policy BandwidthPolicy,
+	rules *pf_rules,
+	solver Solver,
+	deadline time.Time,
+	debug_logging bool) (int, []int, bool, error) {
+
+	//if no interfaces are required
+	if len(requested_interfaces) <= 0 {
+		//request is trivially satisfiable
+		return freeTxRate(pfs_available), []int{}, true, nil
+	}
+
+	search := newSolverSearch(requested_interfaces, pfs_available, policy, rules, solver, deadline, debug_logging)
+	search.place(0)
+
+	//request could not be satisfied, just return empty allocation
+	if !search.found {
+		if search.expired {
+			return freeTxRate(pfs_available), []int{}, false, ErrSolverTimedOut
+		}
+		return freeTxRate(pfs_available), []int{}, false, nil
+	}
+
+	//the search leaves the PFs as they were, so add the chosen
+	//	placement's interfaces to their usage
+	for index, pf_index := range search.best_placements {
+		pfs_available[pf_index].Take(requested_interfaces[index].MinTxRate, requested_interfaces[index].MaxTxRate)
+	}
+	return freeTxRate(pfs_available), search.best_placements, true, nil
+}
@@ -0,0 +1,440 @@
+package rdma_placement
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+)
+
+//testPF returns a PF with the specified capacity and nothing in use.
+func testPF(capacity_tx_rate uint, capacity_vfs uint) PF {
+	var pf PF
+	pf.CapacityTxRate = capacity_tx_rate
+	pf.CapacityVFs = capacity_vfs
+	return pf
+}
+
+//usedPF returns a PF with the specified capacity and one VF in use.
+func usedPF(capacity_tx_rate uint, capacity_vfs uint, used_tx_rate uint) PF {
+	pf := testPF(capacity_tx_rate, capacity_vfs)
+	pf.Take(used_tx_rate, used_tx_rate)
+	return pf
+}
+
+//testInterfaces returns interfaces with the specified min tx rates.
+func testInterfaces(min_tx_rates ...uint) []knapsack_pod_placement.RdmaInterfaceRequest {
+	requested_interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, len(min_tx_rates))
+	for index, min_tx_rate := range min_tx_rates {
+		requested_interfaces[index].MinTxRate = min_tx_rate
+	}
+	return requested_interfaces
+}
+
+//repeatInterfaces returns 'count' interfaces with the same min tx rate.
+func repeatInterfaces(count int, min_tx_rate uint) []knapsack_pod_placement.RdmaInterfaceRequest {
+	min_tx_rates := make([]uint, count)
+	for index := range min_tx_rates {
+		min_tx_rates[index] = min_tx_rate
+	}
+	return testInterfaces(min_tx_rates...)
+}
+
+//repeatPFs returns 'count' copies of a PF.
+func repeatPFs(count int, pf PF) []PF {
+	pfs := make([]PF, count)
+	for index := range pfs {
+		pfs[index] = pf
+	}
+	return pfs
+}
+
+//baselinePlacePod is the plain backtracking search the solver is checked
+//	against: each interface is tried on each PF in the order they were
+//	reported, and nothing is skipped. it returns the first placement found.
+func baselinePlacePod(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs []PF,
+	policy BandwidthPolicy,
+	rules *pf_rules) ([]int, bool) {
+
+	placements := make([]int, len(requested_interfaces))
+	for index := range placements {
+		placements[index] = -1
+	}
+
+	var place func(index int) bool
+	place = func(index int) bool {
+		if index == len(requested_interfaces) {
+			return true
+		}
+		request := &requested_interfaces[index]
+		for pf_index := range pfs {
+			pf := &pfs[pf_index]
+			if !policy.fits(pf, request) || !rules.allows(index, pf_index, pf, placements) {
+				continue
+			}
+			placements[index] = pf_index
+			pf.Take(request.MinTxRate, request.MaxTxRate)
+			if place(index + 1) {
+				return true
+			}
+			pf.Give(request.MinTxRate, request.MaxTxRate)
+			placements[index] = -1
+		}
+		return false
+	}
+
+	if !place(0) {
+		return []int{}, false
+	}
+	return placements, true
+}
+
+//bestScore searches every placement, and returns whether there is one and
+//	the best score any of them has under the goal. the PFs are left as
+//	they were.
+func bestScore(requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest,
+	pfs []PF,
+	policy BandwidthPolicy,
+	rules *pf_rules,
+	goal PlacementGoal) (bool, float64) {
+
+	placements := make([]int, len(requested_interfaces))
+	for index := range placements {
+		placements[index] = -1
+	}
+
+	found := false
+	var best float64
+	var place func(index int)
+	place = func(index int) {
+		if index == len(requested_interfaces) {
+			score := goal.score(pfs, policy)
+			if !found || score > best {
+				found = true
+				best = score
+			}
+			return
+		}
+		request := &requested_interfaces[index]
+		for pf_index := range pfs {
+			pf := &pfs[pf_index]
+			if !policy.fits(pf, request) || !rules.allows(index, pf_index, pf, placements) {
+				continue
+			}
+			placements[index] = pf_index
+			pf.Take(request.MinTxRate, request.MaxTxRate)
+			place(index + 1)
+			pf.Give(request.MinTxRate, request.MaxTxRate)
+			placements[index] = -1
+		}
+	}
+
+	place(0)
+	return found, best
+}
+
+//solver_case is a placement problem the solver is checked on.
+type solver_case struct {
+	name string
+	requested_interfaces []knapsack_pod_placement.RdmaInterfaceRequest
+	pfs []PF
+	policy BandwidthPolicy
+	constraints Constraints
+}
+
+//solverCases returns placement problems that exercise the skipping of
+//	equivalent PFs and interfaces, with and without placement rules, some
+//	of which fit and some of which don't.
+func solverCases() []solver_case {
+	burstable := BandwidthPolicy{Mode: BurstableMode, OversubscriptionRatio: 2}
+	guaranteed := BandwidthPolicy{Mode: GuaranteedMode}
+	best_effort := BandwidthPolicy{Mode: BestEffortMode}
+
+	mixed_pfs := []PF{testPF(1000, 4), usedPF(1000, 4, 300), testPF(2000, 8), testPF(1000, 4), usedPF(2000, 8, 1500), testPF(1000, 4)}
+	capped_interfaces := testInterfaces(300, 300, 500)
+	for index := range capped_interfaces {
+		capped_interfaces[index].MaxTxRate = 2 * capped_interfaces[index].MinTxRate
+	}
+	network_pfs := repeatPFs(4, testPF(1000, 4))
+	network_pfs[1].Network = "storage"
+	network_pfs[3].Network = "storage"
+
+	return []solver_case{
+		{name: "no interfaces", requested_interfaces: testInterfaces(), pfs: repeatPFs(2, testPF(1000, 4)), policy: burstable},
+		{name: "identical interfaces fill identical PFs", requested_interfaces: repeatInterfaces(8, 500), pfs: repeatPFs(4, testPF(1000, 4)), policy: burstable},
+		{name: "one interface too many for identical PFs", requested_interfaces: repeatInterfaces(9, 500), pfs: repeatPFs(4, testPF(1000, 4)), policy: burstable},
+		{name: "too few VFs", requested_interfaces: repeatInterfaces(5, 10), pfs: repeatPFs(2, testPF(1000, 2)), policy: best_effort},
+		{name: "mixed PFs", requested_interfaces: testInterfaces(700, 700, 500, 500, 200, 200, 200), pfs: mixed_pfs, policy: burstable},
+		{name: "mixed PFs that can't fit", requested_interfaces: testInterfaces(900, 900, 900, 900, 900, 900, 900), pfs: mixed_pfs, policy: burstable},
+		{name: "only an early choice fits", requested_interfaces: testInterfaces(600, 600, 400, 400), pfs: []PF{testPF(1000, 4), testPF(1000, 4)}, policy: burstable},
+		{name: "guaranteed mode counts max tx rates", requested_interfaces: capped_interfaces, pfs: repeatPFs(2, testPF(1000, 4)), policy: guaranteed},
+		{name: "guaranteed mode can't fit max tx rates", requested_interfaces: capped_interfaces, pfs: repeatPFs(2, testPF(800, 4)), policy: guaranteed},
+		{name: "identical interfaces kept apart", requested_interfaces: repeatInterfaces(4, 200), pfs: repeatPFs(4, testPF(1000, 4)), policy: burstable,
+			constraints: Constraints{Distinct: []DistinctConstraint{{First: []int{0}, Second: []int{1, 2, 3}}}}},
+		{name: "interfaces kept apart on too few PFs", requested_interfaces: repeatInterfaces(3, 200), pfs: repeatPFs(1, testPF(1000, 4)), policy: burstable,
+			constraints: Constraints{Distinct: []DistinctConstraint{{First: []int{0}, Second: []int{1}}}}},
+		{name: "spread across PFs", requested_interfaces: repeatInterfaces(6, 100), pfs: repeatPFs(4, testPF(1000, 4)), policy: burstable,
+			constraints: Constraints{Spreads: []SpreadConstraint{{Interfaces: []int{0, 1, 2, 3, 4, 5}, MinPFs: 3}}}},
+		{name: "spread across more PFs than there are", requested_interfaces: repeatInterfaces(6, 100), pfs: repeatPFs(2, testPF(1000, 4)), policy: burstable,
+			constraints: Constraints{Spreads: []SpreadConstraint{{Interfaces: []int{0, 1, 2, 3, 4, 5}, MinPFs: 3}}}},
+		{name: "network rule", requested_interfaces: repeatInterfaces(4, 400), pfs: network_pfs, policy: burstable,
+			constraints: Constraints{Networks: []NetworkConstraint{{Interfaces: []int{0, 1}, Network: "storage"}}}},
+		{name: "network without room", requested_interfaces: repeatInterfaces(3, 600), pfs: network_pfs, policy: burstable,
+			constraints: Constraints{Networks: []NetworkConstraint{{Interfaces: []int{0, 1, 2}, Network: "storage"}}}},
+	}
+}
+
+//randomSolverCases returns small random placement problems, small enough
+//	for every placement to be searched.
+func randomSolverCases(count int) []solver_case {
+	random := rand.New(rand.NewSource(1))
+	policies := []BandwidthPolicy{{Mode: BurstableMode, OversubscriptionRatio: 2}, {Mode: GuaranteedMode}, {Mode: BestEffortMode}}
+
+	cases := make([]solver_case, count)
+	for case_index := range cases {
+		pfs := make([]PF, 2 + random.Intn(4))
+		for index := range pfs {
+			pfs[index] = testPF(uint(100 * (1 + random.Intn(2))), uint(2 + random.Intn(3)))
+			if random.Intn(2) == 0 {
+				pfs[index].Take(20, 20)
+			}
+			if random.Intn(4) == 0 {
+				pfs[index].Network = "storage"
+			}
+		}
+
+		//runs of identical interfaces are common, and are what the
+		//	search skips over
+		requested_interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, 1 + random.Intn(6))
+		for index := range requested_interfaces {
+			if index > 0 && random.Intn(2) == 0 {
+				requested_interfaces[index] = requested_interfaces[index - 1]
+				continue
+			}
+			requested_interfaces[index].MinTxRate = uint(10 * (1 + random.Intn(5)))
+			if random.Intn(2) == 0 {
+				requested_interfaces[index].MaxTxRate = 2 * requested_interfaces[index].MinTxRate
+			}
+		}
+
+		var constraints Constraints
+		interface_count := len(requested_interfaces)
+		if interface_count >= 2 && random.Intn(2) == 0 {
+			constraints.Distinct = append(constraints.Distinct, DistinctConstraint{First: []int{0}, Second: []int{1}})
+		}
+		if interface_count >= 3 && random.Intn(3) == 0 {
+			constraints.Spreads = append(constraints.Spreads, SpreadConstraint{Interfaces: []int{interface_count - 3, interface_count - 2, interface_count - 1}, MinPFs: 2})
+		}
+		if random.Intn(4) == 0 {
+			constraints.Networks = append(constraints.Networks, NetworkConstraint{Interfaces: []int{0}, Network: "storage"})
+		}
+
+		cases[case_index] = solver_case{
+			name: fmt.Sprintf("random %d", case_index),
+			requested_interfaces: requested_interfaces,
+			pfs: pfs,
+			policy: policies[case_index % len(policies)],
+			constraints: constraints,
+		}
+	}
+	return cases
+}
+
+func TestFirstFitMatchesBaseline(t *testing.T) {
+	for _, test_case := range append(solverCases(), randomSolverCases(2000)...) {
+		t.Run(test_case.name, func(t *testing.T) {
+			rules := newPFRules(test_case.constraints, len(test_case.requested_interfaces))
+			baseline_pfs := append([]PF(nil), test_case.pfs...)
+			baseline_placements, baseline_success := baselinePlacePod(test_case.requested_interfaces, baseline_pfs, test_case.policy, rules)
+
+			pfs := append([]PF(nil), test_case.pfs...)
+			_, placements, placement_success, err := placePod(test_case.requested_interfaces, pfs, test_case.policy, rules, Solver{}, time.Time{}, false)
+
+			if err != nil {
+				t.Fatalf("search without a deadline failed: %v", err)
+			}
+			if placement_success != baseline_success || !reflect.DeepEqual(placements, baseline_placements) {
+				t.Errorf("placed interfaces on %v (success %v), the baseline search placed them on %v (success %v)", placements, placement_success, baseline_placements, baseline_success)
+			}
+			if !reflect.DeepEqual(pfs, baseline_pfs) {
+				t.Errorf("left PFs as %+v, the baseline search left them as %+v", pfs, baseline_pfs)
+			}
+		})
+	}
+}
+
+func TestGoalsFindPlacementWheneverOneExists(t *testing.T) {
+	goals := []PlacementGoal{FirstFitGoal, LeastFragmentingGoal, BalancedGoal, MostFreeBandwidthGoal}
+	for _, test_case := range append(solverCases(), randomSolverCases(2000)...) {
+		t.Run(test_case.name, func(t *testing.T) {
+			rules := newPFRules(test_case.constraints, len(test_case.requested_interfaces))
+			for _, goal := range goals {
+				exists, best := bestScore(test_case.requested_interfaces, append([]PF(nil), test_case.pfs...), test_case.policy, rules, goal)
+
+				pfs := append([]PF(nil), test_case.pfs...)
+				_, placements, placement_success, err := placePod(test_case.requested_interfaces, pfs, test_case.policy, rules, Solver{Goal: goal}, time.Time{}, false)
+
+				if err != nil {
+					t.Fatalf("%s search without a deadline failed: %v", goal, err)
+				}
+				if placement_success != exists {
+					t.Errorf("%s search found a placement: %v, but one exists: %v", goal, placement_success, exists)
+					continue
+				}
+				if !placement_success {
+					continue
+				}
+				if len(placements) != len(test_case.requested_interfaces) {
+					t.Errorf("%s search placed %d of %d interfaces", goal, len(placements), len(test_case.requested_interfaces))
+				}
+				//cutting branches short must not lose the best placement.
+				//	equivalent placements may add up their scores in a
+				//	different order, so allow for rounding.
+				if goal != FirstFitGoal && math.Abs(goal.score(pfs, test_case.policy) - best) > 1e-9 {
+					t.Errorf("%s search found a placement scoring %v, the best scores %v", goal, goal.score(pfs, test_case.policy), best)
+				}
+			}
+		})
+	}
+}
+
+func TestSolverTimesOut(t *testing.T) {
+	//thirty interfaces that almost fill twelve PFs of slightly different
+	//	sizes take far longer than a millisecond to turn down
+	pfs := make([]PF, 12)
+	for index := range pfs {
+		pfs[index] = testPF(uint(1000 + 7 * index), 8)
+	}
+	requested_interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, 30)
+	for index := range requested_interfaces {
+		requested_interfaces[index].MinTxRate = uint(390 + index)
+	}
+	policy := BandwidthPolicy{Mode: GuaranteedMode}
+
+	_, placements, placement_success, err := PlacePodWithConstraints(requested_interfaces, pfs, policy, Constraints{}, Solver{Budget: time.Millisecond}, false)
+	if placement_success || len(placements) != 0 || err != ErrSolverTimedOut {
+		t.Errorf("expected the search to time out, got placements %v, success %v, error %v", placements, placement_success, err)
+	}
+
+	constraints := Constraints{Networks: []NetworkConstraint{{Interfaces: []int{0}, Network: "storage"}}}
+	kind, description, err := UnmetConstraint(requested_interfaces, pfs, policy, constraints, Solver{Budget: time.Millisecond})
+	if kind != "" || description != "" || err != ErrSolverTimedOut {
+		t.Errorf("expected finding the unmet rule to time out, got %q (%q), error %v", kind, description, err)
+	}
+}
+
+func TestNetworkNamespaces(t *testing.T) {
+	cases := []struct {
+		pf_network string
+		network string
+		fits bool
+	}{
+		{pf_network: "storage", network: "storage", fits: true},
+		{pf_network: "storage", network: "team-a/storage", fits: true},
+		{pf_network: "team-a/storage", network: "team-a/storage", fits: true},
+		{pf_network: "team-a/storage", network: "team-b/storage", fits: false},
+		{pf_network: "team-a/storage", network: "storage", fits: false},
+		{pf_network: "compute", network: "team-a/storage", fits: false},
+	}
+
+	requested_interfaces := testInterfaces(100)
+	for _, test_case := range cases {
+		pfs := []PF{testPF(1000, 8)}
+		pfs[0].Network = test_case.pf_network
+		constraints := Constraints{Networks: []NetworkConstraint{{Interfaces: []int{0}, Network: test_case.network}}}
+		_, _, placement_success, _ := PlacePodWithConstraints(requested_interfaces, pfs, BandwidthPolicy{Mode: GuaranteedMode}, constraints, Solver{}, false)
+		if placement_success != test_case.fits {
+			t.Errorf("PF on network %q, interface asking for %q: expected fits %v, got %v", test_case.pf_network, test_case.network, test_case.fits, placement_success)
+		}
+	}
+}
+
+//benchmarkPFs returns eight PFs, one of them partly in use.
+func benchmarkPFs() []PF {
+	pfs := repeatPFs(8, testPF(1000, 8))
+	pfs[3] = usedPF(1000, 8, 300)
+	return pfs
+}
+
+//smallBenchmarkCases returns a pod of six interfaces that fits on the
+//	benchmark PFs, and a pod of nine that doesn't. every goal searches
+//	all of their placements well within the default budget, and the
+//	baseline search can turn the second one down.
+func smallBenchmarkCases() []solver_case {
+	fitting_interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, 6)
+	for index := range fitting_interfaces {
+		fitting_interfaces[index].MinTxRate = uint(100 + 50 * (index % 3))
+	}
+	policy := BandwidthPolicy{Mode: BurstableMode, OversubscriptionRatio: 2}
+
+	return []solver_case{
+		{name: "small fits", requested_interfaces: fitting_interfaces, pfs: benchmarkPFs(), policy: policy},
+		{name: "small does not fit", requested_interfaces: repeatInterfaces(9, 700), pfs: benchmarkPFs(), policy: policy},
+	}
+}
+
+//benchmarkCases returns a pod of sixteen interfaces that fits on the
+//	benchmark PFs, and one that doesn't, along with the small cases.
+func benchmarkCases() []solver_case {
+	fitting_interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, 16)
+	for index := range fitting_interfaces {
+		fitting_interfaces[index].MinTxRate = uint(100 + 50 * (index % 3))
+	}
+	policy := BandwidthPolicy{Mode: BurstableMode, OversubscriptionRatio: 2}
+
+	return append([]solver_case{
+		{name: "fits", requested_interfaces: fitting_interfaces, pfs: benchmarkPFs(), policy: policy},
+		{name: "does not fit", requested_interfaces: repeatInterfaces(16, 500), pfs: benchmarkPFs(), policy: policy},
+	}, smallBenchmarkCases()...)
+}
+
+//benchmarkPlacement times placing each of the pods in 'cases' on a fresh
+//	copy of its PFs with 'place'.
+func benchmarkPlacement(b *testing.B, cases []solver_case, place func(test_case solver_case, pfs []PF)) {
+	for _, test_case := range cases {
+		b.Run(test_case.name, func(b *testing.B) {
+			pfs := make([]PF, len(test_case.pfs))
+			for i := 0; i < b.N; i++ {
+				copy(pfs, test_case.pfs)
+				place(test_case, pfs)
+			}
+		})
+	}
+}
+
+//benchmarkSolver times placing each of the benchmark pods with a solver.
+func benchmarkSolver(b *testing.B, solver Solver) {
+	benchmarkPlacement(b, benchmarkCases(), func(test_case solver_case, pfs []PF) {
+		PlacePodWithConstraints(test_case.requested_interfaces, pfs, test_case.policy, Constraints{}, solver, false)
+	})
+}
+
+//the baseline search is only timed on the small cases, since it would take
+//	far too long to turn down the sixteen interfaces that don't fit.
+func BenchmarkBaseline(b *testing.B) {
+	benchmarkPlacement(b, smallBenchmarkCases(), func(test_case solver_case, pfs []PF) {
+		baselinePlacePod(test_case.requested_interfaces, pfs, test_case.policy, newPFRules(Constraints{}, len(test_case.requested_interfaces)))
+	})
+}
+
+func BenchmarkFirstFit(b *testing.B) {
+	benchmarkSolver(b, Solver{})
+}
+
+//the goals other than first-fit are timed with the extender's default
+//	budget. the balanced goal can't rule out enough of the placements of
+//	the sixteen interfaces that fit, so it runs until the budget is
+//	used up on that case. the small cases show what a search that
+//	finishes costs.
+func BenchmarkGoals(b *testing.B) {
+	for _, goal := range []PlacementGoal{LeastFragmentingGoal, BalancedGoal, MostFreeBandwidthGoal} {
+		b.Run(string(goal), func(b *testing.B) {
+			benchmarkSolver(b, Solver{Goal: goal, Budget: 50 * time.Millisecond})
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gopswamy/rit-k8s-rdma-common/knapsack_pod_placement"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//structure describing one entry in the list of RDMA interfaces a pod asks
+//	for. 'count' lets a pod ask for several identical interfaces at once,
+//	it defaults to 1 when left out. 'distinct_pf_from' lists other entries
+//	(by index) whose interfaces may not share a PF with this entry's, and
+//	'spread_pfs' spreads this entry's interfaces across at least that many
+//	PFs. 'network' names the network (usually the name of a Multus
+//	NetworkAttachmentDefinition) this entry's interfaces must be on, so
+//	only PFs cabled to it are used.
+type rdma_interface_spec struct {
+	knapsack_pod_placement.RdmaInterfaceRequest
+	Count *uint `json:"count,omitempty"`
+	Network string `json:"network,omitempty"`
+	DistinctPFFrom []int `json:"distinct_pf_from,omitempty"`
+	SpreadPFs *uint `json:"spread_pfs,omitempty"`
+}
+
+//structure describing the NUMA placement rule a pod asks for. 'node' names
+//	the NUMA node the interfaces must go on, for pods whose CPUs are
+//	pinned to it. the extender can't see where the kubelet pins a pod's
+//	CPUs, so it can't work this out for itself. without 'node', any
+//	single NUMA node will do.
+type rdma_numa_spec struct {
+	Policy rdma_placement.NumaPolicy `json:"policy"`
+	Node *int `json:"node,omitempty"`
+}
+
+//structure describing the versioned form of the 'rdma_interfaces_required'
+//	annotation. pods may also use the original form, which is just a bare
+//	list of interfaces.
+type rdma_interfaces_request struct {
+	ApiVersion string `json:"apiVersion"`
+	Interfaces []rdma_interface_spec `json:"interfaces"`
+	Numa *rdma_numa_spec `json:"numa,omitempty"`
+}
+
+//placementConstraints returns the rules a request's interfaces must be
+//	placed by, with interfaces numbered as in the list returned by
+//	expandInterfaceSpecs. like Multus network references, networks
+//	named without a namespace are in the namespace of the pod.
+func (request *rdma_interfaces_request) placementConstraints(namespace string) rdma_placement.Constraints {
+	var constraints rdma_placement.Constraints
+	if(request.Numa != nil) {
+		constraints.Numa.Policy = request.Numa.Policy
+		constraints.Numa.Node = request.Numa.Node
+	}
+
+	//find which of the expanded interfaces each entry became
+	entry_interfaces := make([][]int, len(request.Interfaces))
+	next := 0
+	for i, spec := range request.Interfaces {
+		count := 1
+		if(spec.Count != nil) {
+			count = int(*spec.Count)
+		}
+		for n := 0; n < count; n++ {
+			entry_interfaces[i] = append(entry_interfaces[i], next)
+			next++
+		}
+	}
+
+	for i, spec := range request.Interfaces {
+		if(spec.Network != "") {
+			network := spec.Network
+			if(namespace != "" && !strings.Contains(network, "/")) {
+				network = namespace + "/" + network
+			}
+			constraints.Networks = append(constraints.Networks, rdma_placement.NetworkConstraint{
+				Interfaces: entry_interfaces[i],
+				Network: network,
+				Description: fmt.Sprintf("interfaces[%d] must be on network %s", i, network),
+			})
+		}
+		for _, other := range spec.DistinctPFFrom {
+			constraints.Distinct = append(constraints.Distinct, rdma_placement.DistinctConstraint{
+				First: entry_interfaces[i],
+				Second: entry_interfaces[other],
+				Description: fmt.Sprintf("interfaces[%d] must be on different PFs from interfaces[%d]", i, other),
+			})
+		}
+		if(spec.SpreadPFs != nil && *spec.SpreadPFs > 1) {
+			constraints.Spreads = append(constraints.Spreads, rdma_placement.SpreadConstraint{
+				Interfaces: entry_interfaces[i],
+				MinPFs: int(*spec.SpreadPFs),
+				Description: fmt.Sprintf("interfaces[%d] must be spread across at least %d PFs", i, *spec.SpreadPFs),
+			})
+		}
+	}
+
+	return constraints
+}
+
+//decodeStrict deserializes JSON into a structure, rejecting any fields the
+//	structure doesn't have so that typos in field names are reported.
+func decodeStrict(data string, into interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(into)
+}
+
+//validateInterfaceSpecs checks a list of requested RDMA interfaces, and
+//	returns the errors found with each of their fields.
+func validateInterfaceSpecs(specs []rdma_interface_spec, path *field.Path) field.ErrorList {
+	var all_errs field.ErrorList
+	var total_count uint = 0
+
+	for i, spec := range specs {
+		spec_path := path.Index(i)
+
+		count := uint(1)
+		if(spec.Count != nil) {
+			count = *spec.Count
+			if(count == 0) {
+				all_errs = append(all_errs, field.Invalid(spec_path.Child("count"), count, "must be greater than 0"))
+			}
+		}
+		total_count += count
+
+		if(spec.Network != "") {
+			for _, msg := range validateNetworkName(spec.Network) {
+				all_errs = append(all_errs, field.Invalid(spec_path.Child("network"), spec.Network, msg))
+			}
+		}
+
+		for j, other := range spec.DistinctPFFrom {
+			if(other < 0 || other >= len(specs)) {
+				all_errs = append(all_errs, field.Invalid(spec_path.Child("distinct_pf_from").Index(j), other, fmt.Sprintf("must be the index of an entry in the list (0 to %d)", len(specs) - 1)))
+			} else if(other == i) {
+				all_errs = append(all_errs, field.Invalid(spec_path.Child("distinct_pf_from").Index(j), other, "must not be the entry's own index, use spread_pfs instead"))
+			}
+		}
+		if(spec.SpreadPFs != nil && (*spec.SpreadPFs == 0 || *spec.SpreadPFs > count)) {
+			all_errs = append(all_errs, field.Invalid(spec_path.Child("spread_pfs"), *spec.SpreadPFs, fmt.Sprintf("must be between 1 and the entry's count (%d)", count)))
+		}
+
+		//a max tx rate of 0 means the interface never goes above its
+		//	min tx rate
+		if(spec.MaxTxRate != 0 && spec.MaxTxRate < spec.MinTxRate) {
+			all_errs = append(all_errs, field.Invalid(spec_path.Child("max_tx_rate"), spec.MaxTxRate, fmt.Sprintf("must be no less than min_tx_rate (%d)", spec.MinTxRate)))
+		}
+	}
+
+	if(total_count > RdmaMaxInterfacesPerPod) {
+		all_errs = append(all_errs, field.Invalid(path, total_count, fmt.Sprintf("must ask for no more than %d interfaces in total", RdmaMaxInterfacesPerPod)))
+	}
+
+	return all_errs
+}
+
+//expandInterfaceSpecs turns a list of requested RDMA interfaces into one
+//	entry per interface, repeating entries that have a count.
+func expandInterfaceSpecs(specs []rdma_interface_spec) []knapsack_pod_placement.RdmaInterfaceRequest {
+	interfaces := make([]knapsack_pod_placement.RdmaInterfaceRequest, 0, len(specs))
+	for _, spec := range specs {
+		count := uint(1)
+		if(spec.Count != nil) {
+			count = *spec.Count
+		}
+		for n := uint(0); n < count; n++ {
+			interfaces = append(interfaces, spec.RdmaInterfaceRequest)
+		}
+	}
+	return interfaces
+}
+
+//validateNumaSpec checks the NUMA placement rule of a request, and returns
+//	the errors found with each of its fields.
+func validateNumaSpec(numa *rdma_numa_spec, path *field.Path) field.ErrorList {
+	var all_errs field.ErrorList
+
+	if(numa.Policy != rdma_placement.SingleNumaNodePolicy) {
+		all_errs = append(all_errs, field.NotSupported(path.Child("policy"), numa.Policy, []string{string(rdma_placement.SingleNumaNodePolicy)}))
+	}
+	if(numa.Node != nil && *numa.Node < 0) {
+		all_errs = append(all_errs, field.Invalid(path.Child("node"), *numa.Node, "must not be negative"))
+	}
+
+	return all_errs
+}
+
+//isLegacyRequest determines whether the value of the
+//	'rdma_interfaces_required' annotation uses the original form, which
+//	is a bare list of interfaces.
+func isLegacyRequest(annotation string) bool {
+	return bytes.HasPrefix(bytes.TrimSpace([]byte(annotation)), []byte("["))
+}
+
+//parseRdmaInterfacesRequest reads and validates the value of the
+//	'rdma_interfaces_required' annotation, which may either be a versioned
+//	request object or a bare list of interfaces. problems are reported
+//	per field, with paths rooted at the annotation's name. a bare list is
+//	returned as a request with only its interfaces filled in.
+func parseRdmaInterfacesRequest(annotation string) (rdma_interfaces_request, field.ErrorList) {
+	root_path := field.NewPath(currentConfig().Annotations.InterfacesRequired)
+	var request rdma_interfaces_request
+
+	//the original form of the annotation is a bare list of interfaces.
+	//	unknown fields have always been ignored in it, so they still are.
+	if(isLegacyRequest(annotation)) {
+		err := json.Unmarshal([]byte(annotation), &request.Interfaces)
+		if(err != nil) {
+			return request, field.ErrorList{field.Invalid(root_path, annotation, err.Error())}
+		}
+		return request, validateInterfaceSpecs(request.Interfaces, root_path)
+	}
+
+	err := decodeStrict(annotation, &request)
+	if(err != nil) {
+		return request, field.ErrorList{field.Invalid(root_path, annotation, err.Error())}
+	}
+
+	var all_errs field.ErrorList
+	if(request.ApiVersion == "") {
+		all_errs = append(all_errs, field.Required(root_path.Child("apiVersion"), ""))
+	} else if(request.ApiVersion != RdmaInterfacesRequestApiVersion) {
+		all_errs = append(all_errs, field.NotSupported(root_path.Child("apiVersion"), request.ApiVersion, []string{RdmaInterfacesRequestApiVersion}))
+	}
+	if(len(request.Interfaces) == 0) {
+		all_errs = append(all_errs, field.Required(root_path.Child("interfaces"), "at least one interface must be requested"))
+	}
+	all_errs = append(all_errs, validateInterfaceSpecs(request.Interfaces, root_path.Child("interfaces"))...)
+	if(request.Numa != nil) {
+		all_errs = append(all_errs, validateNumaSpec(request.Numa, root_path.Child("numa"))...)
+	}
+
+	return request, all_errs
+}
+
+//parseRdmaInterfacesRequired reads the list of RDMA interfaces a pod needs,
+//	and the rules they must be placed by, from its annotations. it returns
+//	a nil list if the pod doesn't need any RDMA interfaces, and an error
+//	naming each invalid field if the annotation is malformatted.
+func parseRdmaInterfacesRequired(pod *v1.Pod) ([]knapsack_pod_placement.RdmaInterfaceRequest, rdma_placement.Constraints, error) {
+	annotation := pod.ObjectMeta.Annotations[currentConfig().Annotations.InterfacesRequired]
+	if(annotation == "") {
+		return nil, rdma_placement.Constraints{}, nil
+	}
+
+	request, errs := parseRdmaInterfacesRequest(annotation)
+	if(len(errs) > 0) {
+		return nil, rdma_placement.Constraints{}, errs.ToAggregate()
+	}
+
+	return expandInterfaceSpecs(request.Interfaces), request.placementConstraints(pod.ObjectMeta.Namespace), nil
+}
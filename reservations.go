@@ -0,0 +1,217 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+//structure describing the RDMA resources on one PF that have been handed
+//	out to a pod, but which the DaemonSet on the node may not be
+//	reporting as used yet.
+type pf_reservation struct {
+	vfs uint
+	tx_rate uint
+	max_tx_rate uint
+	//the amount of VFs and bandwidth on the PF that were either reported
+	//	as used or reserved by other pods when this reservation was
+	//	made. once the DaemonSet reports usage past this point plus
+	//	the reservation itself, the reservation is considered confirmed.
+	baseline_used_vfs uint
+	baseline_used_tx_rate uint
+	expires time.Time
+}
+
+//reservation_ledger keeps track of RDMA resources that have been assumed to
+//	be taken by recently bound pods. it is keyed by node name, then PF
+//	name, then the UID of the pod holding the reservation.
+type reservation_ledger struct {
+	lock sync.Mutex
+	ttl time.Duration
+	reservations map[string]map[string]map[types.UID]*pf_reservation
+}
+
+//the ledger shared by all scheduler extender verbs.
+var reservations = newReservationLedger(RdmaSchedulerDefaultReservationTTL)
+
+//newReservationLedger creates an empty ledger whose reservations expire
+//	after the specified amount of time if they are never confirmed.
+func newReservationLedger(ttl time.Duration) *reservation_ledger {
+	return &reservation_ledger{
+		ttl: ttl,
+		reservations: make(map[string]map[string]map[types.UID]*pf_reservation),
+	}
+}
+
+//setTTL changes how long new reservations are held for. reservations that
+//	were already made keep their expiry time.
+func (ledger *reservation_ledger) setTTL(ttl time.Duration) {
+	ledger.lock.Lock()
+	defer ledger.lock.Unlock()
+
+	ledger.ttl = ttl
+}
+
+//reserve records the resources a pod was placed on. 'reported_pfs' are the
+//	node's PFs as the DaemonSet last reported them, and 'placement' lists
+//	the PF chosen for each of the pod's interfaces. any reservation the pod
+//	already held is replaced.
+func (ledger *reservation_ledger) reserve(pod_uid types.UID,
+	node_name string,
+	reported_pfs []rdma_placement.ReportedPF,
+	placement []rdma_interface_placement) {
+
+	ledger.lock.Lock()
+	defer ledger.lock.Unlock()
+
+	ledger.releaseLocked(pod_uid)
+
+	node_reservations := ledger.reservations[node_name]
+	if(node_reservations == nil) {
+		node_reservations = make(map[string]map[types.UID]*pf_reservation)
+		ledger.reservations[node_name] = node_reservations
+	}
+
+	expires := time.Now().Add(ledger.ttl)
+	for _, iface := range placement {
+		pf_reservations := node_reservations[iface.PF]
+		if(pf_reservations == nil) {
+			pf_reservations = make(map[types.UID]*pf_reservation)
+			node_reservations[iface.PF] = pf_reservations
+		}
+
+		reservation := pf_reservations[pod_uid]
+		//the first interface placed on a PF sets up the reservation
+		//	and its baseline
+		if(reservation == nil) {
+			reservation = &pf_reservation{expires: expires}
+			for _, pf := range reported_pfs {
+				if(pf.Name == iface.PF) {
+					reservation.baseline_used_vfs = pf.UsedVFs
+					reservation.baseline_used_tx_rate = pf.UsedTxRate
+				}
+			}
+			for _, other := range pf_reservations {
+				reservation.baseline_used_vfs += other.vfs
+				reservation.baseline_used_tx_rate += other.tx_rate
+			}
+			pf_reservations[pod_uid] = reservation
+		}
+		reservation.vfs += 1
+		reservation.tx_rate += iface.MinTxRate
+		reservation.max_tx_rate += rdma_placement.EffectiveMaxTxRate(iface.MinTxRate, iface.MaxTxRate)
+	}
+}
+
+//release drops any reservation held by a pod, for example because the pod
+//	was deleted or binding it failed.
+func (ledger *reservation_ledger) release(pod_uid types.UID) {
+	ledger.lock.Lock()
+	defer ledger.lock.Unlock()
+
+	ledger.releaseLocked(pod_uid)
+}
+
+//releaseLocked is 'release' for callers that already hold the ledger's lock.
+func (ledger *reservation_ledger) releaseLocked(pod_uid types.UID) {
+	for node_name, node_reservations := range ledger.reservations {
+		for pf_name, pf_reservations := range node_reservations {
+			delete(pf_reservations, pod_uid)
+			if(len(pf_reservations) == 0) {
+				delete(node_reservations, pf_name)
+			}
+		}
+		if(len(node_reservations) == 0) {
+			delete(ledger.reservations, node_name)
+		}
+	}
+}
+
+//applyTo adds the resources reserved on a node to the usage the DaemonSet
+//	reported for its PFs. reservations that have expired, or that the
+//	reported usage shows have been allocated, are dropped along the way.
+//	the reservations held by the 'exclude_uids' pods are left out, so
+//	that pods being re-placed aren't counted against themselves.
+func (ledger *reservation_ledger) applyTo(node_name string, pfs []rdma_placement.PF, exclude_uids ...types.UID) {
+	ledger.lock.Lock()
+	defer ledger.lock.Unlock()
+
+	node_reservations := ledger.reservations[node_name]
+	if(node_reservations == nil) {
+		return
+	}
+
+	now := time.Now()
+	for i := range pfs {
+		pf := &pfs[i]
+		pf_reservations := node_reservations[pf.Name]
+		reported_used_vfs := pf.UsedVFs
+		reported_used_tx_rate := pf.UsedTxRate
+
+		for pod_uid, reservation := range pf_reservations {
+			confirmed := (reported_used_vfs >= reservation.baseline_used_vfs + reservation.vfs) &&
+				(reported_used_tx_rate >= reservation.baseline_used_tx_rate + reservation.tx_rate)
+			if(confirmed || now.After(reservation.expires)) {
+				delete(pf_reservations, pod_uid)
+				continue
+			}
+			if(containsUID(exclude_uids, pod_uid)) {
+				continue
+			}
+			pf.UsedVFs += reservation.vfs
+			pf.UsedTxRate += reservation.tx_rate
+			pf.UsedMaxTxRate += reservation.max_tx_rate
+		}
+		if(pf_reservations != nil && len(pf_reservations) == 0) {
+			delete(node_reservations, pf.Name)
+		}
+	}
+	if(len(node_reservations) == 0) {
+		delete(ledger.reservations, node_name)
+	}
+}
+
+//containsUID determines whether a UID is in a list of them.
+func containsUID(uids []types.UID, uid types.UID) bool {
+	for _, listed := range uids {
+		if(listed == uid) {
+			return true
+		}
+	}
+	return false
+}
+
+//watchPodDeletions releases the reservations of pods as they are deleted.
+func watchPodDeletions(informer_factory informers.SharedInformerFactory, ledger *reservation_ledger) {
+	informer_factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			//the pod may have been deleted while the watch was
+			//	disconnected, in which case we get a tombstone
+			tombstone, is_tombstone := obj.(cache.DeletedFinalStateUnknown)
+			if(is_tombstone) {
+				obj = tombstone.Obj
+			}
+			//only pods placed by the extender, or planned as part
+			//	of a gang, hold reservations
+			pod, is_pod := obj.(*v1.Pod)
+			if(!is_pod) {
+				return
+			}
+			gang, _, in_gang, _ := podGang(pod)
+			if(in_gang && gang != "") {
+				gangs.forget(gang)
+			} else if(pod.ObjectMeta.Annotations[currentConfig().Annotations.InterfacePlacement] == "") {
+				return
+			}
+			log.Println("Releasing RDMA reservations of deleted pod: ", pod.ObjectMeta.Namespace, "/", pod.ObjectMeta.Name)
+			ledger.release(pod.ObjectMeta.UID)
+		},
+	})
+}
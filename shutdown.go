@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//shutdownOnSignal waits for SIGTERM (or an interrupt), then shuts the
+//	extender down gracefully. readiness is flipped to false, and requests
+//	keep being served for the grace period, so that endpoints and
+//	kube-proxy have time to stop sending new ones. then the servers stop
+//	accepting new connections, and work done in the background is
+//	stopped. in-flight requests are given until the drain timeout to
+//	finish, after which their connections are closed, which cancels their
+//	contexts and so any outstanding queries to DaemonSets. 'drained' is
+//	closed once every server has shut down.
+func shutdownOnSignal(servers []*http.Server, grace time.Duration, drain_timeout time.Duration, stop_background context.CancelFunc, drained chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	received := <-signals
+
+	log.Println("Got signal ", received, ", no longer ready, still serving requests for ", grace)
+	atomic.StoreInt32(&shutting_down, 1)
+	time.Sleep(grace)
+
+	log.Println("Draining in-flight requests for up to ", drain_timeout)
+	stop_background()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain_timeout)
+	defer cancel()
+
+	var wait_group sync.WaitGroup
+	for _, server := range servers {
+		wait_group.Add(1)
+		go func(server *http.Server) {
+			defer wait_group.Done()
+			err := server.Shutdown(ctx)
+			if(err != nil) {
+				log.Println("In-flight requests to ", server.Addr, " did not finish in time, closing their connections: ", err)
+				server.Close()
+			}
+		}(server)
+	}
+	wait_group.Wait()
+
+	close(drained)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//whether the scheduler extender verbs only answer clients that presented a
+//	certificate signed by the configured client CA.
+var client_cert_required bool = false
+
+//certificate_reloader serves a TLS certificate and key from files, loading
+//	them again whenever either file changes so that certificates can be
+//	rotated without restarting the extender.
+type certificate_reloader struct {
+	lock sync.Mutex
+	cert_file string
+	key_file string
+	cert *tls.Certificate
+	cert_modified time.Time
+	key_modified time.Time
+}
+
+//newCertificateReloader loads a certificate and key, failing if they can't
+//	be loaded.
+func newCertificateReloader(cert_file string, key_file string) (*certificate_reloader, error) {
+	reloader := &certificate_reloader{
+		cert_file: cert_file,
+		key_file: key_file,
+	}
+	err := reloader.reload()
+	if(err != nil) {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+//reload loads the certificate and key again if either file has changed
+//	since they were last loaded. callers must hold the reloader's lock,
+//	except while the reloader is being created.
+func (reloader *certificate_reloader) reload() error {
+	cert_info, err := os.Stat(reloader.cert_file)
+	if(err != nil) {
+		return err
+	}
+	key_info, err := os.Stat(reloader.key_file)
+	if(err != nil) {
+		return err
+	}
+	if(reloader.cert != nil && cert_info.ModTime().Equal(reloader.cert_modified) && key_info.ModTime().Equal(reloader.key_modified)) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.cert_file, reloader.key_file)
+	if(err != nil) {
+		return err
+	}
+	reloader.cert = &cert
+	reloader.cert_modified = cert_info.ModTime()
+	reloader.key_modified = key_info.ModTime()
+	log.Println("Loaded TLS certificate from: ", reloader.cert_file)
+	return nil
+}
+
+//GetCertificate returns the current certificate, for use in tls.Config. if
+//	the files have changed but can't be loaded (for example because only
+//	one of them has been replaced so far), the previous certificate is
+//	kept.
+func (reloader *certificate_reloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	err := reloader.reload()
+	if(err != nil) {
+		log.Println("Unable to reload TLS certificate, keeping the previous one: ", err)
+	}
+	return reloader.cert, nil
+}
+
+//GetClientCertificate returns the current certificate, for use as a client
+//	certificate in tls.Config.
+func (reloader *certificate_reloader) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return reloader.GetCertificate(nil)
+}
+
+//newServerTLSConfig sets up TLS for a server with the specified certificate
+//	and key. if a client CA file is specified, clients that present a
+//	certificate must have one signed by that CA. presenting one is left
+//	optional here so that probes and metrics scrapes still work; handlers
+//	that need it are wrapped with requireClientCert.
+func newServerTLSConfig(cert_file string, key_file string, client_ca_file string) (*tls.Config, error) {
+	reloader, err := newCertificateReloader(cert_file, key_file)
+	if(err != nil) {
+		return nil, err
+	}
+	tls_config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if(client_ca_file != "") {
+		client_ca_pem, err := ioutil.ReadFile(client_ca_file)
+		if(err != nil) {
+			return nil, err
+		}
+		client_cas := x509.NewCertPool()
+		if(!client_cas.AppendCertsFromPEM(client_ca_pem)) {
+			return nil, errors.New("no certificates found in client CA file " + client_ca_file)
+		}
+		tls_config.ClientCAs = client_cas
+		tls_config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tls_config, nil
+}
+
+//requireClientCert wraps the handler of a scheduler extender verb, or of
+//	anything else only the scheduler should see, so that when client
+//	certificates are required, requests from clients that didn't present
+//	a verified one are turned away.
+func requireClientCert(handle httprouter.Handle) httprouter.Handle {
+	return func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if(client_cert_required && (request.TLS == nil || len(request.TLS.VerifiedChains) == 0)) {
+			log.Println("Rejected request without a verified client certificate from: ", request.RemoteAddr)
+			http.Error(response, "A client certificate is required.", http.StatusUnauthorized)
+			return
+		}
+		handle(response, request, params)
+	}
+}
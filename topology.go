@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+	"sigs.k8s.io/yaml"
+)
+
+//structure describing the fabric topology as it is written in the topology
+//	file: the nodes under each leaf switch, and the nodes on each rail.
+//	a node sits under one leaf, but may be on several rails.
+type fabric_topology_spec struct {
+	Leaves map[string][]string `json:"leaves"`
+	Rails map[string][]string `json:"rails"`
+}
+
+//fabric_topology is the form of the fabric topology used for scoring,
+//	indexed by node.
+type fabric_topology struct {
+	//maps each node to the leaf switch it is under
+	leaves map[string]string
+	//maps each node to the rails it is on
+	rails map[string]map[string]bool
+}
+
+//the fabric topology in use. always holds a *fabric_topology, which is
+//	replaced as a whole (never modified) when the topology is reloaded.
+var active_topology atomic.Value
+
+func init() {
+	active_topology.Store(&fabric_topology{})
+}
+
+//currentTopology returns the fabric topology in use.
+func currentTopology() *fabric_topology {
+	return active_topology.Load().(*fabric_topology)
+}
+
+//parseTopology reads a YAML or JSON fabric topology. unknown fields are
+//	rejected, as are nodes listed under more than one leaf.
+func parseTopology(data []byte) (*fabric_topology, error) {
+	var spec fabric_topology_spec
+	err := yaml.UnmarshalStrict(data, &spec)
+	if(err != nil) {
+		return nil, err
+	}
+
+	topology := &fabric_topology{
+		leaves: make(map[string]string),
+		rails: make(map[string]map[string]bool),
+	}
+	for leaf, node_names := range spec.Leaves {
+		for _, node_name := range node_names {
+			other_leaf, found := topology.leaves[node_name]
+			if(found && other_leaf != leaf) {
+				return nil, fmt.Errorf("node %s is listed under both leaf %s and leaf %s", node_name, other_leaf, leaf)
+			}
+			topology.leaves[node_name] = leaf
+		}
+	}
+	for rail, node_names := range spec.Rails {
+		for _, node_name := range node_names {
+			if(topology.rails[node_name] == nil) {
+				topology.rails[node_name] = make(map[string]bool)
+			}
+			topology.rails[node_name][rail] = true
+		}
+	}
+
+	return topology, nil
+}
+
+//empty determines whether the topology says nothing about any node.
+func (topology *fabric_topology) empty() bool {
+	return len(topology.leaves) == 0 && len(topology.rails) == 0
+}
+
+//shareRail determines whether two nodes are on at least one common rail.
+func (topology *fabric_topology) shareRail(first string, second string) bool {
+	for rail := range topology.rails[first] {
+		if(topology.rails[second][rail]) {
+			return true
+		}
+	}
+	return false
+}
+
+//closeness rates how close a node is on the fabric to the nodes a job's
+//	other pods are on, from 0 (no leaf or rail in common with any of
+//	them) to 1 (a leaf and a rail in common with all of them). sharing a
+//	node counts as sharing both.
+func (topology *fabric_topology) closeness(node_name string, peer_nodes []string, leaf_weight int, rail_weight int) float64 {
+	total := (leaf_weight + rail_weight) * len(peer_nodes)
+	if(total <= 0) {
+		return 0
+	}
+
+	points := 0
+	for _, peer := range peer_nodes {
+		if(peer == node_name) {
+			points += leaf_weight + rail_weight
+			continue
+		}
+		leaf, found := topology.leaves[node_name]
+		if(found && leaf == topology.leaves[peer]) {
+			points += leaf_weight
+		}
+		if(topology.shareRail(node_name, peer)) {
+			points += rail_weight
+		}
+	}
+
+	return float64(points) / float64(total)
+}
+
+//readTopology reads the fabric topology from wherever the settings say it
+//	is, returning its contents and a description of where they came
+//	from. both are empty if no topology is configured.
+func readTopology(config topology_config) ([]byte, string, error) {
+	if(config.File != "") {
+		data, err := ioutil.ReadFile(config.File)
+		return data, "file " + config.File, err
+	}
+	if(config.ConfigMap == "") {
+		return nil, "", nil
+	}
+
+	source := "ConfigMap " + config.ConfigMap + " key " + config.ConfigMapKey
+	if(kube_client == nil) {
+		return nil, source, errors.New("no connection to the k8s API server is available")
+	}
+	parts := strings.SplitN(config.ConfigMap, "/", 2)
+	config_map, err := kube_client.CoreV1().ConfigMaps(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if(err != nil) {
+		return nil, source, err
+	}
+	data, found := config_map.Data[config.ConfigMapKey]
+	if(!found) {
+		return nil, source, errors.New("the ConfigMap has no key " + config.ConfigMapKey)
+	}
+	return []byte(data), source, nil
+}
+
+//topology_loader remembers what the fabric topology was last loaded from,
+//	so that it is only parsed again when that changes.
+type topology_loader struct {
+	source string
+	data []byte
+}
+
+//refresh reloads the fabric topology if it, or where it is read from, has
+//	changed. if it can't be read or is invalid, the current topology is
+//	kept.
+func (loader *topology_loader) refresh() {
+	data, source, err := readTopology(currentConfig().Topology)
+	if(err != nil) {
+		log.Println("Unable to read fabric topology from ", source, ", keeping the current one: ", err)
+		return
+	}
+	if(source == loader.source && bytes.Equal(data, loader.data)) {
+		return
+	}
+
+	topology := &fabric_topology{}
+	if(source != "") {
+		topology, err = parseTopology(data)
+		if(err != nil) {
+			log.Println("Invalid fabric topology in ", source, ", keeping the current one: ", err)
+			return
+		}
+		log.Println("Loaded fabric topology from ", source, ": ", len(topology.leaves), " nodes under leaves, ", len(topology.rails), " nodes on rails")
+	} else {
+		log.Println("No fabric topology is configured, nodes will not be scored by topology")
+	}
+	active_topology.Store(topology)
+	loader.source = source
+	loader.data = data
+}
+
+//watchTopology reloads the fabric topology whenever it changes, until the
+//	context is cancelled.
+func watchTopology(ctx context.Context, loader *topology_loader, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		loader.refresh()
+	}
+}
+
+//jobPeerNodes looks up the nodes that the other pods of a pod's job, as
+//	given by the job label, are bound to. it returns nothing if the pod
+//	has no job label or no pod cache is available.
+func jobPeerNodes(pod *v1.Pod) []string {
+	job_label := currentConfig().Topology.JobLabel
+	job_name := pod.ObjectMeta.Labels[job_label]
+	if(job_name == "" || pod_indexer == nil) {
+		return nil
+	}
+
+	var peer_nodes []string
+	selector := labels.SelectorFromSet(labels.Set{job_label: job_name})
+	err := cache.ListAllByNamespace(pod_indexer, pod.ObjectMeta.Namespace, selector, func(obj interface{}) {
+		peer := obj.(*v1.Pod)
+		if(peer.ObjectMeta.UID == pod.ObjectMeta.UID || peer.Spec.NodeName == "") {
+			return
+		}
+		if(peer.ObjectMeta.DeletionTimestamp != nil || peer.Status.Phase == v1.PodSucceeded || peer.Status.Phase == v1.PodFailed) {
+			return
+		}
+		peer_nodes = append(peer_nodes, peer.Spec.NodeName)
+	})
+	if(err != nil) {
+		log.Println("Unable to look up the other pods of job ", job_name, ": ", err)
+		return nil
+	}
+
+	return peer_nodes
+}
+
+//topologyWeight returns how much of a node's score comes from its
+//	closeness to the peer nodes on the fabric. it is 0 when there is
+//	nothing to be close to, or no topology to measure closeness by.
+func topologyWeight(peer_nodes []string) float64 {
+	config := currentConfig().Topology
+	if(len(peer_nodes) == 0 || config.LeafWeight + config.RailWeight <= 0 || currentTopology().empty()) {
+		return 0
+	}
+	return config.Weight
+}
+
+//topologyCloseness rates how close a node is on the fabric to the peer
+//	nodes, using the configured weights for leaves and rails.
+func topologyCloseness(node_name string, peer_nodes []string) float64 {
+	config := currentConfig().Topology
+	return currentTopology().closeness(node_name, peer_nodes, config.LeafWeight, config.RailWeight)
+}
+
+//weighTopology blends each eligible node's closeness on the fabric to the
+//	other pods of the job into its score, in the proportion set by the
+//	topology weight.
+func weighTopology(scores []int, results []node_eligibility, nodes []v1.Node, peer_nodes []string) {
+	weight := topologyWeight(peer_nodes)
+	if(weight <= 0) {
+		return
+	}
+
+	for i, result := range results {
+		if(!result.enough_resources) {
+			continue
+		}
+		closeness := topologyCloseness(nodes[i].Name, peer_nodes)
+		scores[i] = int(math.Round((1 - weight) * float64(scores[i]) + weight * float64(schedulerapi.MaxPriority) * closeness))
+	}
+}
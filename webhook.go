@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/gopswamy/rit-k8s-rdma-scheduler-extender/rdma_placement"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//structure describing one operation of a JSON patch (RFC 6902), which is
+//	how the mutating webhook describes its changes to a pod.
+type json_patch_operation struct {
+	Op string `json:"op"`
+	Path string `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+//escapeJSONPointer escapes a key for use as one segment of the path of a
+//	JSON patch operation.
+func escapeJSONPointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+//interfacesPath returns the field path of the list of interfaces in the
+//	'rdma_interfaces_required' annotation, which depends on its form.
+func interfacesPath(annotation string) *field.Path {
+	root_path := field.NewPath(currentConfig().Annotations.InterfacesRequired)
+	if(isLegacyRequest(annotation)) {
+		return root_path
+	}
+	return root_path.Child("interfaces")
+}
+
+//checkRequestPossible compares a list of requested RDMA interfaces against
+//	the largest nodes and PFs in the inventory, and returns an error for
+//	each part of the request that no node in the cluster could satisfy.
+//	nothing is reported if the inventory is empty, since there is nothing
+//	to compare against.
+func checkRequestPossible(specs []rdma_interface_spec, path *field.Path) field.ErrorList {
+	var all_errs field.ErrorList
+	largest_tx_rate, most_vfs, known := inventory.largestCapacities()
+	if(!known) {
+		return all_errs
+	}
+
+	var total_count uint = 0
+	for i, spec := range specs {
+		count := uint(1)
+		if(spec.Count != nil) {
+			count = *spec.Count
+		}
+		total_count += count
+
+		//bandwidth doesn't limit where interfaces go in best-effort mode
+		if(clusterBandwidthPolicy().Mode != rdma_placement.BestEffortMode && spec.MinTxRate > largest_tx_rate) {
+			all_errs = append(all_errs, field.Invalid(path.Index(i).Child("min_tx_rate"), spec.MinTxRate, fmt.Sprintf("no PF in the cluster has more than %d bandwidth", largest_tx_rate)))
+		}
+	}
+
+	if(total_count > most_vfs) {
+		all_errs = append(all_errs, field.Invalid(path, total_count, fmt.Sprintf("no node in the cluster has more than %d VFs", most_vfs)))
+	}
+
+	return all_errs
+}
+
+//validatePod rejects pods whose 'rdma_interfaces_required' annotation is
+//	malformatted, or asks for more than any node in the cluster has.
+func validatePod(pod *v1.Pod) *admissionv1beta1.AdmissionResponse {
+	annotation := pod.ObjectMeta.Annotations[currentConfig().Annotations.InterfacesRequired]
+	if(annotation == "") {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	request, errs := parseRdmaInterfacesRequest(annotation)
+	if(len(errs) == 0) {
+		errs = checkRequestPossible(request.Interfaces, interfacesPath(annotation))
+	}
+	if(len(errs) > 0) {
+		return &admissionv1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status: metav1.StatusFailure,
+				Reason: metav1.StatusReasonInvalid,
+				Code: http.StatusUnprocessableEntity,
+				Message: "RDMA Scheduler Extension: invalid RDMA resources request: " + errs.ToAggregate().Error(),
+			},
+		}
+	}
+
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+//mutatePod fills in the defaults of a pod's 'rdma_interfaces_required'
+//	annotation, rewriting it in the versioned form, and adds the resource
+//	managed by the extender to the pod's first container so that the
+//	scheduler only calls the extender for RDMA pods. pods with a
+//	malformatted annotation are left as they are for validatePod to
+//	reject.
+func mutatePod(pod *v1.Pod) *admissionv1beta1.AdmissionResponse {
+	annotation := pod.ObjectMeta.Annotations[currentConfig().Annotations.InterfacesRequired]
+	if(annotation == "") {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+	request, errs := parseRdmaInterfacesRequest(annotation)
+	if(len(errs) > 0 || len(pod.Spec.Containers) == 0) {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+	specs := request.Interfaces
+
+	//an interface that doesn't set a max tx rate never goes above its
+	//	min tx rate, and entries without a count are for one interface
+	for i := range specs {
+		specs[i].MaxTxRate = rdma_placement.EffectiveMaxTxRate(specs[i].MinTxRate, specs[i].MaxTxRate)
+		if(specs[i].Count == nil) {
+			count := uint(1)
+			specs[i].Count = &count
+		}
+	}
+	request.ApiVersion = RdmaInterfacesRequestApiVersion
+	defaulted, err := json.Marshal(request)
+	if(err != nil) {
+		panic(err)
+	}
+	patch := []json_patch_operation{{
+		Op: "replace",
+		Path: "/metadata/annotations/" + escapeJSONPointer(currentConfig().Annotations.InterfacesRequired),
+		Value: string(defaulted),
+	}}
+
+	//extended resources must be requested and limited to the same
+	//	amount. requests aren't defaulted from limits after admission,
+	//	so both have to be set here.
+	container := pod.Spec.Containers[0]
+	marker := map[string]string{string(RdmaExtenderManagedResource): "1"}
+	_, has_limit := container.Resources.Limits[RdmaExtenderManagedResource]
+	_, has_request := container.Resources.Requests[RdmaExtenderManagedResource]
+	if(container.Resources.Limits == nil) {
+		patch = append(patch, json_patch_operation{Op: "add", Path: "/spec/containers/0/resources/limits", Value: marker})
+	} else if(!has_limit) {
+		patch = append(patch, json_patch_operation{Op: "add", Path: "/spec/containers/0/resources/limits/" + escapeJSONPointer(string(RdmaExtenderManagedResource)), Value: "1"})
+	}
+	if(container.Resources.Requests == nil) {
+		patch = append(patch, json_patch_operation{Op: "add", Path: "/spec/containers/0/resources/requests", Value: marker})
+	} else if(!has_request) {
+		patch = append(patch, json_patch_operation{Op: "add", Path: "/spec/containers/0/resources/requests/" + escapeJSONPointer(string(RdmaExtenderManagedResource)), Value: "1"})
+	}
+
+	patch_body, err := json.Marshal(patch)
+	if(err != nil) {
+		panic(err)
+	}
+	patch_type := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: true,
+		Patch: patch_body,
+		PatchType: &patch_type,
+	}
+}
+
+//handleAdmissionReview decodes an AdmissionReview sent by the k8s API server,
+//	passes the pod it is about to the review function, and writes the
+//	review function's response back.
+func handleAdmissionReview(response http.ResponseWriter, request *http.Request, review func(*v1.Pod) *admissionv1beta1.AdmissionResponse) {
+	//reject empty requests
+	if(request.Body == nil) {
+		log.Println("Got empty http admission review request.")
+		http.Error(response, "Request body was empty.", 400)
+		return
+	}
+
+	var admission_review admissionv1beta1.AdmissionReview
+	err := json.NewDecoder(request.Body).Decode(&admission_review)
+	if(err != nil || admission_review.Request == nil) {
+		log.Println("Got http request with malformatted admission review.")
+		http.Error(response, "Malformatted admission review.", 400)
+		return
+	}
+
+	var pod v1.Pod
+	err = json.Unmarshal(admission_review.Request.Object.Raw, &pod)
+	if(err != nil) {
+		log.Println("Got admission review for an object that is not a pod: ", err)
+		http.Error(response, err.Error(), 400)
+		return
+	}
+
+	admission_response := review(&pod)
+	admission_response.UID = admission_review.Request.UID
+	admission_review.Response = admission_response
+	admission_review.Request = nil
+
+	//serialize the review into a response
+	response_body, err := json.Marshal(admission_review)
+	if(err != nil) {
+		panic(err)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(response_body)
+}
+
+// HandleWebhookValidateRequest is a callback function that processes incoming
+//	validating admission reviews for pods.
+func HandleWebhookValidateRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	handleAdmissionReview(response, request, validatePod)
+}
+
+// HandleWebhookMutateRequest is a callback function that processes incoming
+//	mutating admission reviews for pods.
+func HandleWebhookMutateRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	handleAdmissionReview(response, request, mutatePod)
+}
+
+//newWebhookServer sets up the admission webhook server. the k8s API server
+//	only calls webhooks over HTTPS, so it listens separately from the
+//	scheduler extender verbs. its certificate is reloaded when the files
+//	change.
+func newWebhookServer(port string, cert_file string, key_file string) *http.Server {
+	webhook_router := httprouter.New()
+	webhook_router.POST(RdmaWebhookValidatePath, HandleWebhookValidateRequest)
+	webhook_router.POST(RdmaWebhookMutatePath, HandleWebhookMutateRequest)
+
+	tls_config, err := newServerTLSConfig(cert_file, key_file, "")
+	if(err != nil) {
+		log.Fatal("Unable to set up TLS for admission webhook: ", err)
+	}
+	return &http.Server{
+		Addr: ":" + port,
+		Handler: webhook_router,
+		TLSConfig: tls_config,
+	}
+}
+
+//serveWebhook runs the admission webhook server until it is shut down.
+func serveWebhook(server *http.Server) {
+	log.Println("RDMA admission webhook listening on: ", server.Addr)
+	err := server.ListenAndServeTLS("", "")
+	if(err != nil && err != http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}